@@ -0,0 +1,316 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// scheduledRoutine is a routine assigned to a day of a week schedule.
+type scheduledRoutine struct {
+	id, name string
+}
+
+// weekPlan is what a user planned in one week, keyed by day name.
+type weekPlan struct {
+	start time.Time
+	days  map[string][]scheduledRoutine
+}
+
+// plannedSchedules returns the routines of each week schedule a user
+// stored up to the week starting on last, by week start and day.
+func (db *DB) plannedSchedules(userID, last string) (map[string]map[string][]scheduledRoutine, error) {
+	rows, err := db.Query(`
+		SELECT ws.week_start::text, ds.day, r.id, r.name
+		FROM week_schedules ws
+		LEFT JOIN day_schedules ds ON ds.week_id = ws.id
+		LEFT JOIN day_routines dr ON dr.day_id = ds.id
+		LEFT JOIN routines r ON r.id = dr.routine_id AND r.retired_at IS NULL
+		WHERE ws.user_id = $1 AND ws.week_start <= $2
+		ORDER BY ws.week_start, dr.position`, userID, last)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	weeks := map[string]map[string][]scheduledRoutine{}
+	for rows.Next() {
+		var start string
+		var day, id, name sql.NullString
+		if err := rows.Scan(&start, &day, &id, &name); err != nil {
+			return nil, err
+		}
+		if weeks[start] == nil {
+			weeks[start] = map[string][]scheduledRoutine{}
+		}
+		if id.Valid {
+			weeks[start][day.String] = append(weeks[start][day.String], scheduledRoutine{id: id.String, name: name.String})
+		}
+	}
+	return weeks, rows.Err()
+}
+
+// plannedPrograms returns the routines of the program weeks a user's
+// enrollments cover up to the week starting on last, by week start and day.
+// Where enrollments overlap the one started last wins, as in programWeek.
+func (db *DB) plannedPrograms(userID, last string) (map[string]map[string][]scheduledRoutine, error) {
+	rows, err := db.Query(`
+		SELECT (date_trunc('week', e.start_date)::date + (pw.week_number - 1) * 7)::text AS week_start,
+		       e.id, pwr.day, r.id, r.name
+		FROM program_enrollments e
+		JOIN program_weeks pw ON pw.program_id = e.program_id
+		LEFT JOIN program_week_routines pwr ON pwr.week_id = pw.id
+		LEFT JOIN routines r ON r.id = pwr.routine_id AND r.retired_at IS NULL
+		WHERE e.user_id = $1 AND date_trunc('week', e.start_date)::date + (pw.week_number - 1) * 7 <= $2
+		ORDER BY week_start, e.start_date DESC, e.created_at DESC, pwr.position`, userID, last)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	weeks := map[string]map[string][]scheduledRoutine{}
+	enrollments := map[string]string{}
+	for rows.Next() {
+		var start, enrollmentID string
+		var day, id, name sql.NullString
+		if err := rows.Scan(&start, &enrollmentID, &day, &id, &name); err != nil {
+			return nil, err
+		}
+		if enrollments[start] == "" {
+			enrollments[start] = enrollmentID
+			weeks[start] = map[string][]scheduledRoutine{}
+		} else if enrollments[start] != enrollmentID {
+			continue
+		}
+		if id.Valid {
+			weeks[start][day.String] = append(weeks[start][day.String], scheduledRoutine{id: id.String, name: name.String})
+		}
+	}
+	return weeks, rows.Err()
+}
+
+// loadWeekPlans returns what a user planned each week, as the week schedule
+// shows it, from the first week they had a schedule, program or recurrence
+// for up to the week of today, oldest first.
+func (db *DB) loadWeekPlans(userID string, today time.Time) ([]weekPlan, error) {
+	var first sql.NullString
+	err := db.QueryRow(`
+		SELECT MIN(week_start)::text FROM (
+			SELECT week_start FROM week_schedules WHERE user_id = $1
+			UNION ALL
+			SELECT date_trunc('week', start_date)::date FROM program_enrollments WHERE user_id = $1
+			UNION ALL
+			SELECT date_trunc('week', start_date)::date FROM routine_recurrences WHERE user_id = $1
+		) starts`, userID).Scan(&first)
+	if err != nil || !first.Valid {
+		return nil, err
+	}
+	start, err := time.Parse(dateLayout, first.String)
+	if err != nil {
+		return nil, err
+	}
+	last := weekStart(today)
+
+	schedules, err := db.plannedSchedules(userID, last.Format(dateLayout))
+	if err != nil {
+		return nil, err
+	}
+	programs, err := db.plannedPrograms(userID, last.Format(dateLayout))
+	if err != nil {
+		return nil, err
+	}
+	occurrences, err := db.occurrences(userID, first.String, last.AddDate(0, 0, 6).Format(dateLayout))
+	if err != nil {
+		return nil, err
+	}
+
+	// A week without its own schedule follows the latest one before it,
+	// and a program week replaces either
+	var plans []weekPlan
+	var template map[string][]scheduledRoutine
+	for ; !start.After(last); start = start.AddDate(0, 0, 7) {
+		key := start.Format(dateLayout)
+		if days, ok := schedules[key]; ok {
+			template = days
+		}
+		days := template
+		if program, ok := programs[key]; ok {
+			days = program
+		}
+		plan := weekPlan{start: start, days: map[string][]scheduledRoutine{}}
+		for day, routines := range days {
+			plan.days[day] = append([]scheduledRoutine(nil), routines...)
+		}
+		plans = append(plans, plan)
+	}
+
+	// Occurrences come after the day's routines unless already planned
+	for _, o := range occurrences {
+		d, err := time.Parse(dateLayout, o.Date)
+		if err != nil {
+			return nil, err
+		}
+		plan := &plans[int(d.Sub(plans[0].start).Hours()/24/7)]
+		day := d.Weekday().String()
+		planned := false
+		for _, routine := range plan.days[day] {
+			planned = planned || routine.id == o.RoutineID
+		}
+		if !planned {
+			plan.days[day] = append(plan.days[day], scheduledRoutine{id: o.RoutineID, name: o.RoutineName})
+		}
+	}
+	return plans, nil
+}
+
+// plannedOn returns the routines planned on day.
+func plannedOn(plans []weekPlan, day time.Time) []scheduledRoutine {
+	i := sort.Search(len(plans), func(i int) bool { return plans[i].start.After(day) })
+	if i == 0 {
+		return nil
+	}
+	return plans[i-1].days[day.Weekday().String()]
+}
+
+// GetUserAdherence compares a user's week schedule with what they logged.
+// A scheduled day counts as completed when progress was logged for one of
+// its routines. The breakdown covers the last weeks (default 12) in the
+// user's timezone; streaks are counted over the whole history. Today is
+// left out until something is logged.
+func (db *DB) GetUserAdherence(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	params := r.URL.Query()
+
+	weeks := 12
+	if v := params.Get("weeks"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 104 {
+			http.Error(w, "weeks must be between 1 and 104", http.StatusBadRequest)
+			return
+		}
+		weeks = n
+	}
+
+	loc, err := db.userLocation(userID, params.Get("timezone"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	first := weekStart(today).AddDate(0, 0, -7*(weeks-1))
+
+	adherence := Adherence{
+		UserID: userID,
+		From:   first.Format(dateLayout),
+		To:     today.Format(dateLayout),
+		Weeks:  make([]WeeklyAdherence, weeks),
+		Missed: []MissedDay{},
+	}
+	for i := range adherence.Weeks {
+		adherence.Weeks[i].WeekStart = first.AddDate(0, 0, 7*i).Format(dateLayout)
+	}
+
+	plans, err := db.loadWeekPlans(userID, today)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(plans) == 0 {
+		writeJSON(w, http.StatusOK, adherence)
+		return
+	}
+	historyStart := plans[0].start
+
+	// Routines the user logged anything for, by day
+	rows, err := db.Query(`
+		SELECT DISTINCT up.date::text, w.routine_id
+		FROM user_progress up
+		JOIN workouts w ON w.id = up.workout_id
+		WHERE up.user_id = $1 AND up.date BETWEEN $2::date AND $3::date`,
+		userID, historyStart.Format(dateLayout), adherence.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	logged := map[string]map[string]bool{}
+	for rows.Next() {
+		var date, routineID string
+		if err := rows.Scan(&date, &routineID); err != nil {
+			continue
+		}
+		if logged[date] == nil {
+			logged[date] = map[string]bool{}
+		}
+		logged[date][routineID] = true
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var planned, completed, streak int
+	for day := historyStart; !day.After(today); day = day.AddDate(0, 0, 1) {
+		routines := plannedOn(plans, day)
+		if len(routines) == 0 {
+			continue
+		}
+
+		date := day.Format(dateLayout)
+		done := false
+		for _, routine := range routines {
+			if logged[date][routine.id] {
+				done = true
+				break
+			}
+		}
+		if !done && day.Equal(today) {
+			continue
+		}
+
+		if done {
+			streak++
+			adherence.LongestStreak = max(adherence.LongestStreak, streak)
+		} else {
+			streak = 0
+		}
+
+		if day.Before(first) {
+			continue
+		}
+		week := &adherence.Weeks[int(day.Sub(first).Hours()/24/7)]
+		week.Planned++
+		planned++
+		if done {
+			week.Completed++
+			completed++
+			continue
+		}
+
+		missed := MissedDay{Date: date, Day: day.Weekday().String(), Routines: []string{}}
+		for _, routine := range routines {
+			missed.Routines = append(missed.Routines, routine.name)
+		}
+		adherence.Missed = append(adherence.Missed, missed)
+	}
+	adherence.CurrentStreak = streak
+
+	for i := range adherence.Weeks {
+		if week := &adherence.Weeks[i]; week.Planned > 0 {
+			week.CompletionPercent = round1(float64(week.Completed) / float64(week.Planned) * 100)
+		}
+	}
+	if planned > 0 {
+		adherence.CompletionPercent = round1(float64(completed) / float64(planned) * 100)
+	}
+	writeJSON(w, http.StatusOK, adherence)
+}
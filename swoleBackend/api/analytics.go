@@ -0,0 +1,344 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Estimate returns the estimated one-rep max for reps at weight. It reports
+// false when the formula is undefined for that many reps.
+func (f OneRepMaxFormula) Estimate(weight float64, reps int) (float64, bool) {
+	if weight <= 0 || reps < 1 {
+		return 0, false
+	}
+	if reps == 1 {
+		return weight, true
+	}
+	switch f {
+	case Brzycki:
+		if reps >= 37 {
+			return 0, false
+		}
+		return weight * 36 / float64(37-reps), true
+	default:
+		return weight * (1 + float64(reps)/30), true
+	}
+}
+
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
+
+// slopePerWeek fits e1RM against time by least squares and returns the
+// change per week.
+func slopePerWeek(points []StrengthPoint) float64 {
+	if len(points) < 2 {
+		return 0
+	}
+	first, _ := time.Parse(dateLayout, points[0].Date)
+
+	var sumX, sumY, sumXY, sumXX float64
+	for _, p := range points {
+		day, _ := time.Parse(dateLayout, p.Date)
+		x := day.Sub(first).Hours() / 24 / 7
+		sumX += x
+		sumY += p.E1RM
+		sumXY += x * p.E1RM
+		sumXX += x * x
+	}
+	n := float64(len(points))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denominator
+}
+
+// buildStrengthTrend fills in the rolling trend and summary for an
+// exercise's daily points, which must be in date order.
+func buildStrengthTrend(trend *StrengthTrend, window int) {
+	var sum float64
+	for i := range trend.Points {
+		p := &trend.Points[i]
+		sum += p.E1RM
+		if i >= window {
+			sum -= trend.Points[i-window].E1RM
+		}
+		p.Trend = round1(sum / float64(min(i+1, window)))
+		if p.E1RM > trend.Best {
+			trend.Best = p.E1RM
+		}
+	}
+
+	last := trend.Points[len(trend.Points)-1]
+	trend.Latest = last.E1RM
+	trend.ChangePerWeek = round1(slopePerWeek(trend.Points))
+	if first := trend.Points[0].Trend; first > 0 {
+		trend.ChangePercent = round1((last.Trend - first) / first * 100)
+	}
+}
+
+// addRelativeStrength expresses each point's e1RM as a multiple of the
+// user's bodyweight on that day and summarises the best and latest.
+func addRelativeStrength(trend *StrengthTrend, weighIns []weighIn) {
+	for i := range trend.Points {
+		p := &trend.Points[i]
+		p.Bodyweight = bodyweightOn(weighIns, p.Date)
+		if p.Bodyweight == nil {
+			continue
+		}
+		relative := math.Round(p.E1RM / *p.Bodyweight * 100) / 100
+		p.Relative = &relative
+		if trend.RelativeBest == nil || relative > *trend.RelativeBest {
+			trend.RelativeBest = p.Relative
+		}
+		trend.RelativeLatest = p.Relative
+	}
+}
+
+// validDateRange checks the optional from/to query parameters, writing a
+// 400 response and returning false if either is not a YYYY-MM-DD date.
+func validDateRange(w http.ResponseWriter, params url.Values) bool {
+	for _, bound := range []string{"from", "to"} {
+		if v := params.Get(bound); v != "" {
+			if _, err := time.Parse(dateLayout, v); err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s %q, expected YYYY-MM-DD", bound, v), http.StatusBadRequest)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// GetStrengthAnalytics returns the estimated one-rep max of each lift per
+// logged day, with a rolling trend and rate of change. Optional parameters:
+// formula (epley or brzycki), exercise_id (repeatable, id or slug), from/to
+// and window (logged days averaged for the trend, default 4). Once the user
+// has recorded their bodyweight, each e1RM is also given relative to it.
+func (db *DB) GetStrengthAnalytics(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	userID, ok := db.requireUserID(w, params.Get("user_id"))
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+
+	formula := Epley
+	if f := params.Get("formula"); f != "" {
+		formula = OneRepMaxFormula(f)
+		if !formula.Valid() {
+			http.Error(w, "formula must be epley or brzycki", http.StatusBadRequest)
+			return
+		}
+	}
+
+	window := 4
+	if v := params.Get("window"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 52 {
+			http.Error(w, "window must be between 1 and 52", http.StatusBadRequest)
+			return
+		}
+		window = n
+	}
+
+	if !validDateRange(w, params) {
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT e.id, e.name, p.date::text, p.weight, p.reps
+		FROM `+loggedPerformances+`
+		JOIN exercises e ON e.id = p.exercise_id
+		WHERE p.user_id = $1 AND p.weight > 0 AND p.reps > 0
+		  AND (cardinality($2::text[]) = 0 OR e.id::text = ANY($2) OR e.slug = ANY($2))
+		  AND ($3 = '' OR p.date >= NULLIF($3, '')::date)
+		  AND ($4 = '' OR p.date <= NULLIF($4, '')::date)
+		ORDER BY e.name, e.id, p.date`,
+		userID, pq.Array(listParam(r, "exercise_id")), params.Get("from"), params.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	analytics := StrengthAnalytics{
+		UserID:    userID,
+		Formula:   formula,
+		Window:    window,
+		Exercises: []StrengthTrend{},
+	}
+	var current *StrengthTrend
+	for rows.Next() {
+		var exerciseID, name, date string
+		var weight float64
+		var reps int
+		if err := rows.Scan(&exerciseID, &name, &date, &weight, &reps); err != nil {
+			continue
+		}
+		e1rm, ok := formula.Estimate(weight, reps)
+		if !ok {
+			continue
+		}
+
+		if current == nil || current.ExerciseID != exerciseID {
+			analytics.Exercises = append(analytics.Exercises, StrengthTrend{
+				ExerciseID:   exerciseID,
+				ExerciseName: name,
+				Points:       []StrengthPoint{},
+			})
+			current = &analytics.Exercises[len(analytics.Exercises)-1]
+		}
+
+		// Keep only the day's best set
+		point := StrengthPoint{Date: date, E1RM: round1(e1rm), Weight: weight, Reps: reps}
+		if n := len(current.Points); n > 0 && current.Points[n-1].Date == date {
+			if point.E1RM > current.Points[n-1].E1RM {
+				current.Points[n-1] = point
+			}
+			continue
+		}
+		current.Points = append(current.Points, point)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	weighIns, err := db.loadWeighIns(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range analytics.Exercises {
+		if len(analytics.Exercises[i].Points) > 0 {
+			buildStrengthTrend(&analytics.Exercises[i], window)
+			addRelativeStrength(&analytics.Exercises[i], weighIns)
+			analytics.Exercises[i].inUnit(unit)
+		}
+	}
+	writeJSON(w, http.StatusOK, analytics)
+}
+
+// weekStart returns the Monday on or before day.
+func weekStart(day time.Time) time.Time {
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
+
+// GetVolumeAnalytics returns weekly tonnage and hard-set counts per muscle
+// group for the last weeks (default 12) Monday-to-Sunday weeks, counted in
+// the user's timezone. A workout's volume counts towards each of its muscle
+// groups; workouts without muscle groups are left out.
+func (db *DB) GetVolumeAnalytics(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	userID, ok := db.requireUserID(w, params.Get("user_id"))
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+
+	weeks := 12
+	if v := params.Get("weeks"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 104 {
+			http.Error(w, "weeks must be between 1 and 104", http.StatusBadRequest)
+			return
+		}
+		weeks = n
+	}
+
+	loc, err := db.userLocation(userID, params.Get("timezone"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	first := weekStart(today).AddDate(0, 0, -7*(weeks-1))
+
+	analytics := VolumeAnalytics{
+		UserID: userID,
+		From:   first.Format(dateLayout),
+		To:     today.Format(dateLayout),
+		Weeks:  make([]WeeklyVolume, weeks),
+		Totals: []MuscleGroupVolume{},
+	}
+	for i := range analytics.Weeks {
+		analytics.Weeks[i] = WeeklyVolume{
+			WeekStart:    first.AddDate(0, 0, 7*i).Format(dateLayout),
+			MuscleGroups: []MuscleGroupVolume{},
+		}
+	}
+
+	rows, err := db.Query(`
+		SELECT date_trunc('week', p.date)::date::text, mg,
+		       COALESCE(SUM(p.weight * p.reps * p.sets), 0),
+		       SUM(p.sets),
+		       COALESCE(SUM(p.sets) FILTER (WHERE p.rpe IS NULL OR p.rpe >= 7), 0)
+		FROM `+loggedPerformances+`
+		JOIN workouts w ON w.id = p.workout_id
+		CROSS JOIN UNNEST(w.muscle_groups) AS mg
+		WHERE p.user_id = $1 AND p.date BETWEEN $2::date AND $3::date
+		GROUP BY 1, 2
+		ORDER BY 1, 2`, userID, analytics.From, analytics.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	totals := map[string]*MuscleGroupVolume{}
+	for rows.Next() {
+		var week string
+		var v MuscleGroupVolume
+		if err := rows.Scan(&week, &v.MuscleGroup, &v.Tonnage, &v.Sets, &v.HardSets); err != nil {
+			continue
+		}
+		v.Tonnage = round1(v.Tonnage)
+
+		start, _ := time.Parse(dateLayout, week)
+		i := int(start.Sub(first).Hours() / 24 / 7)
+		if i < 0 || i >= weeks {
+			continue
+		}
+		analytics.Weeks[i].MuscleGroups = append(analytics.Weeks[i].MuscleGroups, v)
+
+		total := totals[v.MuscleGroup]
+		if total == nil {
+			total = &MuscleGroupVolume{MuscleGroup: v.MuscleGroup}
+			totals[v.MuscleGroup] = total
+		}
+		total.Tonnage = round1(total.Tonnage + v.Tonnage)
+		total.Sets += v.Sets
+		total.HardSets += v.HardSets
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, total := range totals {
+		analytics.Totals = append(analytics.Totals, *total)
+	}
+	sort.Slice(analytics.Totals, func(i, j int) bool {
+		return analytics.Totals[i].MuscleGroup < analytics.Totals[j].MuscleGroup
+	})
+	analytics.inUnit(unit)
+	writeJSON(w, http.StatusOK, analytics)
+}
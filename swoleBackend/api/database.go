@@ -53,20 +53,71 @@ func InitDB() (*DB, error) {
 	return &DB{db}, nil
 }
 
+// exerciseSlugSQL is the SQL equivalent of workoutKey applied to column.
+func exerciseSlugSQL(column string) string {
+	return fmt.Sprintf(`TRIM(BOTH '-' FROM REGEXP_REPLACE(LOWER(%s), '[^a-z0-9]+', '-', 'g'))`, column)
+}
+
+// linkExercises links workouts that predate the exercise catalog to it by
+// name, adding missing exercises, and returns how many it linked.
+func linkExercises(q queryer) (int64, error) {
+	_, err := q.Exec(`
+		INSERT INTO exercises (slug, name, type, exercise_type)
+		SELECT DISTINCT ON (slug) slug, name, type, exercise_type
+		FROM (
+			SELECT ` + exerciseSlugSQL("name") + ` AS slug, name, type, exercise_type, created_at
+			FROM workouts WHERE exercise_id IS NULL
+		) unlinked
+		ORDER BY slug, created_at
+		ON CONFLICT (slug) DO NOTHING`)
+	if err != nil {
+		return 0, err
+	}
+	res, err := q.Exec(`
+		UPDATE workouts w SET exercise_id = e.id
+		FROM exercises e
+		WHERE w.exercise_id IS NULL AND e.slug = ` + exerciseSlugSQL("w.name"))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
 func (db *DB) CreateTables() error {
 	queries := []string{
 		`CREATE TABLE IF NOT EXISTS users (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 			email VARCHAR(255) UNIQUE NOT NULL,
 			name VARCHAR(255) NOT NULL,
+			timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+			weight_unit VARCHAR(2) NOT NULL DEFAULT 'lb',
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
 		
+		// IANA timezone used to decide which day a log belongs to
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT 'UTC'`,
+		
+		// Unit weights are shown in (lb or kg); they are always stored in pounds
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS weight_unit VARCHAR(2) NOT NULL DEFAULT 'lb'`,
+		
 		`CREATE TABLE IF NOT EXISTS routines (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 			name VARCHAR(255) NOT NULL,
 			description TEXT,
+			category VARCHAR(50),
+			difficulty VARCHAR(50),
+			duration_minutes INTEGER,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS exercises (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			slug VARCHAR(255) UNIQUE NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			type VARCHAR(50),
+			exercise_type VARCHAR(50) NOT NULL,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
@@ -74,6 +125,7 @@ func (db *DB) CreateTables() error {
 		`CREATE TABLE IF NOT EXISTS workouts (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 			routine_id UUID REFERENCES routines(id) ON DELETE CASCADE,
+			exercise_id UUID REFERENCES exercises(id) ON DELETE SET NULL,
 			name VARCHAR(255) NOT NULL,
 			type VARCHAR(50),
 			exercise_type VARCHAR(50) NOT NULL,
@@ -82,10 +134,41 @@ func (db *DB) CreateTables() error {
 			reps INTEGER,
 			sets INTEGER,
 			description TEXT,
+			instructions TEXT,
+			muscle_groups TEXT[],
+			progression JSONB,
+			position INTEGER NOT NULL DEFAULT 0,
+			sync_key VARCHAR(255),
+			retired_at TIMESTAMP,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
 		
+		// Older databases predate explicit workout ordering
+		`ALTER TABLE workouts ADD COLUMN IF NOT EXISTS position INTEGER NOT NULL DEFAULT 0`,
+		
+		`CREATE INDEX IF NOT EXISTS idx_workouts_routine_position ON workouts(routine_id, position)`,
+		
+		// Columns used by the YAML sync to match and soft-retire workouts
+		`ALTER TABLE workouts ADD COLUMN IF NOT EXISTS sync_key VARCHAR(255)`,
+		`ALTER TABLE workouts ADD COLUMN IF NOT EXISTS retired_at TIMESTAMP`,
+		
+		// Deleted routines are retired, so progress logged against them is kept
+		`ALTER TABLE routines ADD COLUMN IF NOT EXISTS retired_at TIMESTAMP`,
+		
+		// Routine and workout metadata carried by routines.yaml
+		`ALTER TABLE routines ADD COLUMN IF NOT EXISTS category VARCHAR(50)`,
+		`ALTER TABLE routines ADD COLUMN IF NOT EXISTS difficulty VARCHAR(50)`,
+		`ALTER TABLE routines ADD COLUMN IF NOT EXISTS duration_minutes INTEGER`,
+		`ALTER TABLE workouts ADD COLUMN IF NOT EXISTS instructions TEXT`,
+		`ALTER TABLE workouts ADD COLUMN IF NOT EXISTS muscle_groups TEXT[]`,
+		
+		// Workouts share the exercise catalog; older ones are linked by linkExercises
+		`ALTER TABLE workouts ADD COLUMN IF NOT EXISTS exercise_id UUID REFERENCES exercises(id) ON DELETE SET NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_workouts_exercise_id ON workouts(exercise_id)`,
+		
+		// Declarative progression rule, see ProgressionRule
+		`ALTER TABLE workouts ADD COLUMN IF NOT EXISTS progression JSONB`,
 		`CREATE TABLE IF NOT EXISTS week_schedules (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
@@ -119,6 +202,199 @@ func (db *DB) CreateTables() error {
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			UNIQUE(user_id, workout_id, date)
 		)`,
+		
+		// Session timestamps are absolute instants, so they carry a time zone
+		`CREATE TABLE IF NOT EXISTS workout_sessions (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			routine_id UUID REFERENCES routines(id) ON DELETE SET NULL,
+			started_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			ended_at TIMESTAMPTZ,
+			notes TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		
+		`CREATE INDEX IF NOT EXISTS idx_workout_sessions_user_started ON workout_sessions(user_id, started_at)`,
+		
+		`ALTER TABLE user_progress ADD COLUMN IF NOT EXISTS session_id UUID REFERENCES workout_sessions(id) ON DELETE SET NULL`,
+		
+		// Bumped on every write so clients can sync changes
+		`ALTER TABLE user_progress ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP`,
+		
+		// Row versions, sent as ETags so concurrent edits can be detected
+		`ALTER TABLE user_progress ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1`,
+		`ALTER TABLE routines ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1`,
+		`ALTER TABLE week_schedules ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1`,
+		
+		`CREATE TABLE IF NOT EXISTS workout_sets (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			workout_id UUID REFERENCES workouts(id) ON DELETE CASCADE,
+			date DATE NOT NULL,
+			set_number INTEGER NOT NULL,
+			reps INTEGER,
+			weight DECIMAL,
+			duration INTEGER,
+			rpe DECIMAL(3,1),
+			is_warmup BOOLEAN NOT NULL DEFAULT FALSE,
+			session_id UUID REFERENCES workout_sessions(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(user_id, workout_id, date, set_number)
+		)`,
+		
+		`CREATE INDEX IF NOT EXISTS idx_workout_sets_user_workout_date ON workout_sets(user_id, workout_id, date)`,
+		
+		`ALTER TABLE workout_sets ADD COLUMN IF NOT EXISTS session_id UUID REFERENCES workout_sessions(id) ON DELETE SET NULL`,
+		
+		`CREATE TABLE IF NOT EXISTS personal_records (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			exercise_id UUID REFERENCES exercises(id) ON DELETE CASCADE,
+			workout_id UUID REFERENCES workouts(id) ON DELETE CASCADE,
+			record_type VARCHAR(20) NOT NULL,
+			value DECIMAL NOT NULL,
+			previous_value DECIMAL,
+			weight DECIMAL,
+			reps INTEGER,
+			date DATE NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		
+		`CREATE INDEX IF NOT EXISTS idx_personal_records_user_exercise ON personal_records(user_id, exercise_id, date)`,
+		
+		`CREATE TABLE IF NOT EXISTS insights (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			exercise_id UUID REFERENCES exercises(id) ON DELETE CASCADE,
+			kind VARCHAR(20) NOT NULL,
+			metric VARCHAR(20) NOT NULL,
+			sessions INTEGER NOT NULL,
+			best DECIMAL NOT NULL,
+			latest DECIMAL NOT NULL,
+			change_percent DECIMAL NOT NULL,
+			suggestion TEXT NOT NULL,
+			suggested_weight DECIMAL,
+			detected_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(user_id, exercise_id, kind)
+		)`,
+		
+		// Audit times are compared with the retention window, so they carry a time zone
+		`CREATE TABLE IF NOT EXISTS progress_audit (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			progress_id UUID NOT NULL,
+			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			action VARCHAR(10) NOT NULL,
+			snapshot JSONB NOT NULL,
+			restored_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		
+		`CREATE INDEX IF NOT EXISTS idx_progress_audit_user_created ON progress_audit(user_id, created_at)`,
+		
+		// Offline mutations already applied, so retried batches are idempotent
+		`CREATE TABLE IF NOT EXISTS sync_mutations (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			client_id VARCHAR(100) NOT NULL,
+			mutation_type VARCHAR(30) NOT NULL,
+			entity_id UUID,
+			result JSONB NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(user_id, client_id)
+		)`,
+		
+		// Bodyweight, body fat and circumferences, at most one entry per user per day
+		`CREATE TABLE IF NOT EXISTS body_measurements (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			date DATE NOT NULL,
+			bodyweight DECIMAL,
+			body_fat DECIMAL(4,1),
+			neck DECIMAL,
+			chest DECIMAL,
+			waist DECIMAL,
+			hips DECIMAL,
+			arm DECIMAL,
+			thigh DECIMAL,
+			calf DECIMAL,
+			notes TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(user_id, date)
+		)`,
+		
+		// Multi-week programs: ordered weeks of day plans with load and rep
+		// modifiers, and the users following them
+		`CREATE TABLE IF NOT EXISTS programs (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			name VARCHAR(255) NOT NULL,
+			description TEXT,
+			version INTEGER NOT NULL DEFAULT 1,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS program_weeks (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			program_id UUID REFERENCES programs(id) ON DELETE CASCADE,
+			week_number INTEGER NOT NULL,
+			name VARCHAR(255),
+			load_percent DECIMAL NOT NULL DEFAULT 100,
+			rep_change INTEGER NOT NULL DEFAULT 0,
+			UNIQUE(program_id, week_number)
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS program_week_routines (
+			week_id UUID REFERENCES program_weeks(id) ON DELETE CASCADE,
+			day VARCHAR(20) NOT NULL,
+			routine_id UUID REFERENCES routines(id) ON DELETE CASCADE,
+			position INTEGER DEFAULT 0,
+			PRIMARY KEY (week_id, day, routine_id)
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS program_enrollments (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			program_id UUID REFERENCES programs(id) ON DELETE CASCADE,
+			start_date DATE NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		
+		`CREATE INDEX IF NOT EXISTS idx_program_enrollments_user_start ON program_enrollments(user_id, start_date)`,
+		
+		// Routines a user cycles through in order, regardless of weekday
+		`CREATE TABLE IF NOT EXISTS rotations (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(255),
+			position INTEGER NOT NULL DEFAULT 0,
+			version INTEGER NOT NULL DEFAULT 1,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		
+		`CREATE TABLE IF NOT EXISTS rotation_routines (
+			rotation_id UUID REFERENCES rotations(id) ON DELETE CASCADE,
+			position INTEGER NOT NULL,
+			routine_id UUID REFERENCES routines(id) ON DELETE CASCADE,
+			PRIMARY KEY (rotation_id, position)
+		)`,
+		
+		// Routines planned by RFC 5545 recurrence rule rather than by weekday
+		`CREATE TABLE IF NOT EXISTS routine_recurrences (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			routine_id UUID REFERENCES routines(id) ON DELETE CASCADE,
+			rrule TEXT NOT NULL,
+			start_date DATE NOT NULL,
+			exceptions DATE[] NOT NULL DEFAULT '{}',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		
+		`CREATE INDEX IF NOT EXISTS idx_routine_recurrences_user_id ON routine_recurrences(user_id)`,
 	}
 	
 	for _, query := range queries {
@@ -128,6 +404,83 @@ func (db *DB) CreateTables() error {
 		}
 	}
 	
+	if _, err := linkExercises(db); err != nil {
+		return fmt.Errorf("error linking exercises: %v", err)
+	}
+	if err := db.migrateWeekStarts(); err != nil {
+		return fmt.Errorf("error migrating week schedules: %v", err)
+	}
+	if err := db.migrateRoutineNames(); err != nil {
+		return fmt.Errorf("error migrating routine names: %v", err)
+	}
+	err := db.uniqueIndex(`CREATE UNIQUE INDEX IF NOT EXISTS idx_workout_sessions_user_open
+		ON workout_sessions(user_id) WHERE ended_at IS NULL`, "users have more than one open session")
+	if err != nil {
+		return fmt.Errorf("error creating open session index: %v", err)
+	}
+	
 	log.Println("All tables created successfully!")
 	return nil
+}
+
+// migrateWeekStarts makes weeks start on Monday, one schedule per user per
+// week. Seeded weeks started on the Sunday before; of duplicate weeks the
+// newest is kept. It runs once: the unique index it ends with (or the
+// constraint init.sql creates) marks it done.
+func (db *DB) migrateWeekStarts() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serializes servers starting at the same time
+	if _, err := tx.Exec(`LOCK TABLE week_schedules IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+	var done bool
+	err = tx.QueryRow(`
+		SELECT to_regclass('idx_week_schedules_user_week') IS NOT NULL
+		    OR to_regclass('week_schedules_user_id_week_start_key') IS NOT NULL`).Scan(&done)
+	if err != nil || done {
+		return err
+	}
+
+	migrations := []string{
+		`UPDATE week_schedules SET week_start = week_start + 1 WHERE EXTRACT(ISODOW FROM week_start) = 7`,
+		`DELETE FROM week_schedules a USING week_schedules b
+			WHERE a.user_id = b.user_id AND a.week_start = b.week_start
+			AND (a.created_at, a.id) < (b.created_at, b.id)`,
+		`CREATE UNIQUE INDEX idx_week_schedules_user_week ON week_schedules(user_id, week_start)`,
+	}
+	for _, query := range migrations {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	log.Println("Moved week schedules to Monday starts")
+	return tx.Commit()
+}
+
+// uniqueIndex creates a unique index that rows stored before it may
+// violate. Databases holding duplicates go on without the index, with a
+// warning naming them, until they are cleaned up.
+func (db *DB) uniqueIndex(query, duplicates string) error {
+	_, err := db.Exec(query)
+	if isUniqueViolation(err) {
+		log.Printf("Warning: %s, skipping their unique index: %v", duplicates, err)
+		return nil
+	}
+	return err
+}
+
+// migrateRoutineNames makes routine names unique among live routines only,
+// so a retired routine's name can be used again. Databases from init.sql
+// had a plain unique constraint; ones created here had none.
+func (db *DB) migrateRoutineNames() error {
+	if _, err := db.Exec(`ALTER TABLE routines DROP CONSTRAINT IF EXISTS routines_name_key`); err != nil {
+		return err
+	}
+	return db.uniqueIndex(`CREATE UNIQUE INDEX IF NOT EXISTS idx_routines_active_name
+		ON routines(name) WHERE retired_at IS NULL`, "live routines share names")
 }
\ No newline at end of file
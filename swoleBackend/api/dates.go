@@ -0,0 +1,100 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// loadLocation parses an IANA timezone name such as "America/New_York".
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return nil, fmt.Errorf("timezone is required")
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+	return loc, nil
+}
+
+// userLocation returns the timezone used to decide what "today" means for a
+// user. A timezone sent with the request wins over the stored preference;
+// unknown users fall back to UTC.
+func (db *DB) userLocation(userID, override string) (*time.Location, error) {
+	if override != "" {
+		return loadLocation(override)
+	}
+
+	var name string
+	err := db.QueryRow(`SELECT timezone FROM users WHERE id::text = $1`, userID).Scan(&name)
+	if err == sql.ErrNoRows {
+		return time.UTC, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return loadLocation(name)
+}
+
+// logDate picks the calendar day a log entry belongs to. An explicit
+// YYYY-MM-DD date wins, then a timestamp converted into loc, then the
+// current day in loc. Dates after today in loc are rejected.
+func logDate(loc *time.Location, date string, at *time.Time) (string, error) {
+	today := time.Now().In(loc).Format(dateLayout)
+
+	switch {
+	case date != "":
+		if _, err := time.Parse(dateLayout, date); err != nil {
+			return "", fmt.Errorf("invalid date %q, expected YYYY-MM-DD", date)
+		}
+	case at != nil:
+		date = at.In(loc).Format(dateLayout)
+	default:
+		return today, nil
+	}
+
+	// YYYY-MM-DD strings compare in calendar order
+	if date > today {
+		return "", fmt.Errorf("date %s is in the future", date)
+	}
+	return date, nil
+}
+
+// requireLogDate resolves the day for a log entry, writing a 400 response
+// and returning false if the date or timezone is invalid.
+func (db *DB) requireLogDate(w http.ResponseWriter, userID, timezone, date string, at *time.Time) (string, bool) {
+	loc, err := db.userLocation(userID, timezone)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	day, err := logDate(loc, date, at)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return day, true
+}
+
+// requestDate resolves the day a read request is about: ?date=YYYY-MM-DD,
+// or today for the user (honouring ?timezone=). userID may be an email or
+// UUID and need not exist.
+func (db *DB) requestDate(r *http.Request, userID string) (string, error) {
+	params := r.URL.Query()
+	if date := params.Get("date"); date != "" {
+		if _, err := time.Parse(dateLayout, date); err != nil {
+			return "", fmt.Errorf("invalid date %q, expected YYYY-MM-DD", date)
+		}
+		return date, nil
+	}
+
+	actualUserID, _ := db.resolveUserID(userID)
+	loc, err := db.userLocation(actualUserID, params.Get("timezone"))
+	if err != nil {
+		return "", err
+	}
+	return time.Now().In(loc).Format(dateLayout), nil
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const exerciseQuery = `
+	SELECT e.id, e.slug, e.name, e.type, e.exercise_type, e.created_at, e.updated_at,
+	       ARRAY(
+	           SELECT DISTINCT mg FROM workouts mw, UNNEST(mw.muscle_groups) mg
+	           WHERE mw.exercise_id = e.id AND mw.retired_at IS NULL ORDER BY mg
+	       ) AS muscle_groups,
+	       (SELECT COUNT(DISTINCT rw.routine_id) FROM workouts rw
+	        WHERE rw.exercise_id = e.id AND rw.retired_at IS NULL) AS routine_count
+	FROM exercises e`
+
+func scanExercise(row interface{ Scan(...interface{}) error }) (*Exercise, error) {
+	var e Exercise
+	var workoutType sql.NullString
+
+	err := row.Scan(&e.ID, &e.Slug, &e.Name, &workoutType, &e.ExerciseType, &e.CreatedAt, &e.UpdatedAt,
+		pq.Array(&e.MuscleGroups), &e.RoutineCount)
+	if err != nil {
+		return nil, err
+	}
+	if workoutType.Valid {
+		wt := WorkoutType(workoutType.String)
+		e.Type = &wt
+	}
+	if e.MuscleGroups == nil {
+		e.MuscleGroups = []string{}
+	}
+	return &e, nil
+}
+
+// GetExercises lists the exercise catalog. Optional filters: q (name
+// search), type and exercise_type.
+func (db *DB) GetExercises(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	rows, err := db.Query(exerciseQuery+`
+		WHERE ($1 = '' OR e.name ILIKE '%' || $1 || '%')
+		  AND ($2 = '' OR e.type = $2)
+		  AND ($3 = '' OR e.exercise_type = $3)
+		ORDER BY e.name`,
+		params.Get("q"), params.Get("type"), params.Get("exercise_type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	exercises := []Exercise{}
+	for rows.Next() {
+		e, err := scanExercise(rows)
+		if err != nil {
+			continue
+		}
+		exercises = append(exercises, *e)
+	}
+
+	writeJSON(w, http.StatusOK, exercises)
+}
+
+// GetExerciseHistory returns a user's logged progress for an exercise
+// across every routine it appears in, newest first.
+func (db *DB) GetExerciseHistory(w http.ResponseWriter, r *http.Request) {
+	exerciseID := mux.Vars(r)["id"]
+
+	userID, ok := db.requireUserID(w, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+
+	exercise, err := scanExercise(db.QueryRow(exerciseQuery+` WHERE e.id::text = $1 OR e.slug = $1`, exerciseID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Exercise not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT up.date::text, up.weight, up.time, w.id, r.id, r.name
+		FROM user_progress up
+		JOIN workouts w ON w.id = up.workout_id
+		JOIN routines r ON r.id = w.routine_id
+		WHERE up.user_id = $1 AND w.exercise_id = $2
+		ORDER BY up.date DESC, r.name`, userID, exercise.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	history := ExerciseHistory{
+		Exercise: *exercise,
+		UserID:   userID,
+		Entries:  []ExerciseHistoryEntry{},
+	}
+	for rows.Next() {
+		var entry ExerciseHistoryEntry
+		var weight sql.NullFloat64
+		var t sql.NullInt64
+
+		err := rows.Scan(&entry.Date, &weight, &t, &entry.WorkoutID, &entry.RoutineID, &entry.RoutineName)
+		if err != nil {
+			continue
+		}
+
+		if weight.Valid {
+			entry.Weight = &weight.Float64
+			if history.BestWeight == nil || weight.Float64 > *history.BestWeight {
+				history.BestWeight = &weight.Float64
+			}
+		}
+		if t.Valid {
+			v := int(t.Int64)
+			entry.Time = &v
+			if history.BestTime == nil || v > *history.BestTime {
+				history.BestTime = &v
+			}
+		}
+		history.Entries = append(history.Entries, entry)
+	}
+
+	history.inUnit(unit)
+	writeJSON(w, http.StatusOK, history)
+}
@@ -3,97 +3,137 @@ package api
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 	"log"
 )
 
+// routineMeta holds the nullable metadata columns shared by every routine query.
+type routineMeta struct {
+	category, difficulty sql.NullString
+	duration             sql.NullInt64
+}
+
+func (m routineMeta) apply(routine *Routine) {
+	if m.category.Valid {
+		routine.Category = &m.category.String
+	}
+	if m.difficulty.Valid {
+		routine.Difficulty = &m.difficulty.String
+	}
+	if m.duration.Valid {
+		d := int(m.duration.Int64)
+		routine.DurationMinutes = &d
+	}
+}
+
+// resolveUserID maps a user identifier (email or UUID) to the user's UUID.
+func (db *DB) resolveUserID(identifier string) (string, error) {
+	var userID string
+	// First try to get user by email, then by ID
+	err := db.QueryRow("SELECT id FROM users WHERE email = $1 LIMIT 1", identifier).Scan(&userID)
+	if err == sql.ErrNoRows {
+		err = db.QueryRow("SELECT id FROM users WHERE id::text = $1 LIMIT 1", identifier).Scan(&userID)
+	}
+	return userID, err
+}
+
+// requireUserID resolves the user_id query parameter, writing a 400 response
+// and returning false if it is missing or unknown.
+func (db *DB) requireUserID(w http.ResponseWriter, identifier string) (string, bool) {
+	if identifier == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return "", false
+	}
+	userID, err := db.resolveUserID(identifier)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusBadRequest)
+		return "", false
+	}
+	return userID, true
+}
+
 func (db *DB) GetWeekSchedule(w http.ResponseWriter, r *http.Request) {
 	userID := r.URL.Query().Get("user_id")
 	if userID == "" {
 		userID = "default" // For now, use a default user
 	}
 	
-	// Get current week schedule
-	query := `
-		SELECT ws.id, ws.week_start, ds.id, ds.day, r.id, r.name, r.description
-		FROM week_schedules ws
-		LEFT JOIN day_schedules ds ON ds.week_id = ws.id
-		LEFT JOIN day_routines dr ON dr.day_id = ds.id
-		LEFT JOIN routines r ON r.id = dr.routine_id
-		WHERE ws.user_id = (SELECT id FROM users WHERE email = $1 OR id::text = $1 LIMIT 1)
-		ORDER BY ws.week_start DESC, 
-			CASE ds.day
-				WHEN 'Monday' THEN 1
-				WHEN 'Tuesday' THEN 2
-				WHEN 'Wednesday' THEN 3
-				WHEN 'Thursday' THEN 4
-				WHEN 'Friday' THEN 5
-				WHEN 'Saturday' THEN 6
-				WHEN 'Sunday' THEN 7
-			END,
-			dr.position
-		LIMIT 100`
-	
-	rows, err := db.Query(query, userID)
+	date, err := db.requestDate(r, userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer rows.Close()
-	
-	weekSchedule := WeekSchedule{
-		Schedule: []DaySchedule{},
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
 	}
 	
-	dayMap := make(map[string]*DaySchedule)
-	days := []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
-	
-	// Initialize all days
-	for _, day := range days {
-		ds := DaySchedule{
-			Day:      day,
-			Routines: []Routine{},
-		}
-		weekSchedule.Schedule = append(weekSchedule.Schedule, ds)
-		dayMap[day] = &weekSchedule.Schedule[len(weekSchedule.Schedule)-1]
+	start, err := requestWeek(r, date)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 	
-	for rows.Next() {
-		var wsID, weekStart, dsID, dsDay sql.NullString
-		var rID, rName, rDesc sql.NullString
-		
-		err := rows.Scan(&wsID, &weekStart, &dsID, &dsDay, &rID, &rName, &rDesc)
-		if err != nil {
-			continue
-		}
-		
-		if dsDay.Valid && rID.Valid {
-			if day, exists := dayMap[dsDay.String]; exists {
-				routine := Routine{
-					ID:   rID.String,
-					Name: rName.String,
-				}
-				if rDesc.Valid {
-					routine.Description = &rDesc.String
-				}
-				
-				// Get workouts for this routine with user progress
-				routine.Workouts = db.getWorkoutsForRoutineWithProgress(rID.String, userID)
-				day.Routines = append(day.Routines, routine)
-			}
-		}
+	weekSchedule, err := db.resolveWeek(userID, start, date, unit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 	
+	if weekSchedule.ID != "" {
+		setETag(w, weekSchedule.Version)
+	}
+	weekSchedule.inUnit(unit)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(weekSchedule)
 }
 
+// GetDaySchedule returns what a user trains on one day (?date=, default
+// today): the day of their week schedule with its date and any recurring
+// routines.
+func (db *DB) GetDaySchedule(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		userID = "default" // For now, use a default user
+	}
+
+	date, err := db.requestDate(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+
+	day, err := time.Parse(dateLayout, date)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	week, err := db.resolveWeek(userID, weekStart(day).Format(dateLayout), date, unit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	week.inUnit(unit)
+	writeJSON(w, http.StatusOK, week.Schedule[(int(day.Weekday())+6)%7])
+}
+
 func (db *DB) getWorkoutsForRoutine(routineID string) []Workout {
-	return db.getWorkoutsForRoutineWithProgress(routineID, "")
+	return db.getWorkoutsForRoutineWithProgress(routineID, "", "")
 }
 
-func (db *DB) getWorkoutsForRoutineWithProgress(routineID string, userID string) []Workout {
+// getWorkoutsForRoutineWithProgress fills in the user's progress for date
+// (YYYY-MM-DD), or for the user's current day when date is empty.
+func (db *DB) getWorkoutsForRoutineWithProgress(routineID string, userID string, date string) []Workout {
 	// Get the actual user UUID
 	var actualUserID string
 	if userID != "" {
@@ -111,18 +151,27 @@ func (db *DB) getWorkoutsForRoutineWithProgress(routineID string, userID string)
 		// Default to first user
 		db.QueryRow("SELECT id FROM users LIMIT 1").Scan(&actualUserID)
 	}
+	
+	if date == "" {
+		loc, err := db.userLocation(actualUserID, "")
+		if err != nil {
+			loc = time.UTC
+		}
+		date = time.Now().In(loc).Format(dateLayout)
+	}
 
 	query := `
-		SELECT w.id, w.name, w.type, w.exercise_type, w.weight, w.time, w.reps, w.sets, w.description,
+		SELECT w.id, w.exercise_id, w.name, w.type, w.exercise_type, w.weight, w.time, w.reps, w.sets, w.description,
+		       w.instructions, w.muscle_groups, w.progression, w.position,
 		       up.weight as user_weight, up.time as user_time
 		FROM workouts w
 		LEFT JOIN user_progress up ON w.id = up.workout_id 
 			AND up.user_id = $2
-			AND up.date = CURRENT_DATE
-		WHERE w.routine_id = $1
-		ORDER BY w.created_at`
+			AND up.date = $3
+		WHERE w.routine_id = $1 AND w.retired_at IS NULL
+		ORDER BY w.position, w.created_at`
 	
-	rows, err := db.Query(query, routineID, actualUserID)
+	rows, err := db.Query(query, routineID, actualUserID, date)
 	if err != nil {
 		return []Workout{}
 	}
@@ -131,16 +180,24 @@ func (db *DB) getWorkoutsForRoutineWithProgress(routineID string, userID string)
 	var workouts []Workout
 	for rows.Next() {
 		var w Workout
-		var workoutType, description sql.NullString
+		var exerciseID, workoutType, description, instructions sql.NullString
 		var weight, userWeight sql.NullFloat64
-		var time, userTime, reps, sets sql.NullInt64
+		var duration, userTime, reps, sets sql.NullInt64
+		var progression []byte
 		
-		err := rows.Scan(&w.ID, &w.Name, &workoutType, &w.ExerciseType,
-			&weight, &time, &reps, &sets, &description, &userWeight, &userTime)
+		err := rows.Scan(&w.ID, &exerciseID, &w.Name, &workoutType, &w.ExerciseType,
+			&weight, &duration, &reps, &sets, &description, &instructions, pq.Array(&w.MuscleGroups),
+			&progression, &w.Position, &userWeight, &userTime)
 		if err != nil {
 			continue
 		}
+		if w.Progression, err = parseProgression(progression); err != nil {
+			continue
+		}
 		
+		if exerciseID.Valid {
+			w.ExerciseID = &exerciseID.String
+		}
 		if workoutType.Valid {
 			wt := WorkoutType(workoutType.String)
 			w.Type = &wt
@@ -148,8 +205,8 @@ func (db *DB) getWorkoutsForRoutineWithProgress(routineID string, userID string)
 		if weight.Valid {
 			w.Weight = &weight.Float64
 		}
-		if time.Valid {
-			t := int(time.Int64)
+		if duration.Valid {
+			t := int(duration.Int64)
 			w.Time = &t
 		}
 		if reps.Valid {
@@ -163,6 +220,12 @@ func (db *DB) getWorkoutsForRoutineWithProgress(routineID string, userID string)
 		if description.Valid {
 			w.Description = &description.String
 		}
+		if instructions.Valid {
+			w.Instructions = &instructions.String
+		}
+		if w.MuscleGroups == nil {
+			w.MuscleGroups = []string{}
+		}
 		
 		// Add user progress if available
 		if userWeight.Valid {
@@ -176,15 +239,72 @@ func (db *DB) getWorkoutsForRoutineWithProgress(routineID string, userID string)
 		workouts = append(workouts, w)
 	}
 	
+	// Suggestions are personal, so skip them for the anonymous listing
+	if userID != "" {
+		if err := db.applyProgression(routineID, actualUserID, date, workouts); err != nil {
+			log.Printf("Error applying progression rules: %v", err)
+		}
+	}
+	
 	return workouts
 }
 
+// routineFilters builds the WHERE clause for the optional GetRoutines
+// query parameters: category, difficulty, muscle_group and max_duration.
+func routineFilters(r *http.Request) (string, []interface{}, error) {
+	q := r.URL.Query()
+	conditions := []string{"r.retired_at IS NULL"}
+	var args []interface{}
+	
+	if category := q.Get("category"); category != "" {
+		args = append(args, category)
+		conditions = append(conditions, fmt.Sprintf("LOWER(r.category) = LOWER($%d)", len(args)))
+	}
+	if difficulty := q.Get("difficulty"); difficulty != "" {
+		args = append(args, difficulty)
+		conditions = append(conditions, fmt.Sprintf("LOWER(r.difficulty) = LOWER($%d)", len(args)))
+	}
+	if group := q.Get("muscle_group"); group != "" {
+		args = append(args, strings.ToLower(group))
+		conditions = append(conditions, fmt.Sprintf(`EXISTS (
+			SELECT 1 FROM workouts w
+			WHERE w.routine_id = r.id AND w.retired_at IS NULL AND $%d = ANY(w.muscle_groups))`, len(args)))
+	}
+	if maxDuration := q.Get("max_duration"); maxDuration != "" {
+		minutes, err := strconv.Atoi(maxDuration)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid max_duration %q", maxDuration)
+		}
+		args = append(args, minutes)
+		conditions = append(conditions, fmt.Sprintf("r.duration_minutes <= $%d", len(args)))
+	}
+	
+	return "WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
 func (db *DB) GetRoutines(w http.ResponseWriter, r *http.Request) {
 	userID := r.URL.Query().Get("user_id")
 	
-	query := `SELECT id, name, description FROM routines ORDER BY name`
+	where, args, err := routineFilters(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	
+	date, err := db.requestDate(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+	
+	query := `SELECT r.id, r.name, r.description, r.category, r.difficulty, r.duration_minutes, r.version
+		FROM routines r ` + where + ` ORDER BY r.name`
 	
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -195,8 +315,10 @@ func (db *DB) GetRoutines(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var routine Routine
 		var description sql.NullString
+		var meta routineMeta
 		
-		err := rows.Scan(&routine.ID, &routine.Name, &description)
+		err := rows.Scan(&routine.ID, &routine.Name, &description,
+			&meta.category, &meta.difficulty, &meta.duration, &routine.Version)
 		if err != nil {
 			continue
 		}
@@ -204,9 +326,11 @@ func (db *DB) GetRoutines(w http.ResponseWriter, r *http.Request) {
 		if description.Valid {
 			routine.Description = &description.String
 		}
+		meta.apply(&routine)
 		
 		// Get workouts for each routine with user progress
-		routine.Workouts = db.getWorkoutsForRoutineWithProgress(routine.ID, userID)
+		routine.Workouts = db.getWorkoutsForRoutineWithProgress(routine.ID, userID, date)
+		routine.inUnit(unit)
 		routines = append(routines, routine)
 	}
 	
@@ -219,11 +343,24 @@ func (db *DB) GetRoutine(w http.ResponseWriter, r *http.Request) {
 	routineID := vars["id"]
 	userID := r.URL.Query().Get("user_id")
 	
+	date, err := db.requestDate(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+	
 	var routine Routine
 	var description sql.NullString
+	var meta routineMeta
 	
-	query := `SELECT id, name, description FROM routines WHERE id = $1`
-	err := db.QueryRow(query, routineID).Scan(&routine.ID, &routine.Name, &description)
+	query := `SELECT id, name, description, category, difficulty, duration_minutes, version
+		FROM routines WHERE id = $1 AND retired_at IS NULL`
+	err = db.QueryRow(query, routineID).Scan(&routine.ID, &routine.Name, &description,
+		&meta.category, &meta.difficulty, &meta.duration, &routine.Version)
 	if err != nil {
 		http.Error(w, "Routine not found", http.StatusNotFound)
 		return
@@ -232,9 +369,12 @@ func (db *DB) GetRoutine(w http.ResponseWriter, r *http.Request) {
 	if description.Valid {
 		routine.Description = &description.String
 	}
+	meta.apply(&routine)
 	
-	routine.Workouts = db.getWorkoutsForRoutineWithProgress(routineID, userID)
+	routine.Workouts = db.getWorkoutsForRoutineWithProgress(routineID, userID, date)
+	routine.inUnit(unit)
 	
+	setETag(w, routine.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(routine)
 }
@@ -245,8 +385,12 @@ func (db *DB) UpdateWorkoutProgress(w http.ResponseWriter, r *http.Request) {
 	
 	var update struct {
 		UserID     string   `json:"user_id"`
-		UserWeight *float64 `json:"userWeight"`
-		UserTime   *int     `json:"userTime"`
+		SessionID  string     `json:"session_id"`
+		UserWeight *float64   `json:"userWeight"`
+		UserTime   *int       `json:"userTime"`
+		Date       string     `json:"date"`
+		LoggedAt   *time.Time `json:"logged_at"`
+		Timezone   string     `json:"timezone"`
 	}
 	
 	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
@@ -255,101 +399,108 @@ func (db *DB) UpdateWorkoutProgress(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Get the actual user UUID
-	var actualUserID string
-	if update.UserID != "" {
-		// First try to get user by email, then by ID
-		err := db.QueryRow("SELECT id FROM users WHERE email = $1 LIMIT 1", update.UserID).Scan(&actualUserID)
-		if err != nil {
-			// If not found by email, try by ID
-			err = db.QueryRow("SELECT id FROM users WHERE id::text = $1 LIMIT 1", update.UserID).Scan(&actualUserID)
-			if err != nil {
-				http.Error(w, "User not found", http.StatusBadRequest)
-				return
-			}
-		}
-	} else {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+	actualUserID, ok := db.requireUserID(w, update.UserID)
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, actualUserID)
+	if !ok {
+		return
+	}
+	update.UserWeight = unit.pounds(update.UserWeight)
+	if exists, err := db.workoutExists(workoutID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if !exists {
+		http.Error(w, "Workout not found", http.StatusNotFound)
+		return
+	}
+	
+	// Backdated entries send date or logged_at; otherwise it's the user's today
+	date, ok := db.requireLogDate(w, actualUserID, update.Timezone, update.Date, update.LoggedAt)
+	if !ok {
+		return
+	}
+	
+	// Attach to the named session, or the user's open one
+	sessionID, err := sessionForLog(db, actualUserID, update.SessionID,
+		update.Date != "" || update.LoggedAt != nil)
+	if err == errUnknownSession {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	
 	// Insert progress record
 	query := `
-		INSERT INTO user_progress (user_id, workout_id, weight, time, date)
-		VALUES ($1, $2, $3, $4, CURRENT_DATE)
+		INSERT INTO user_progress (user_id, workout_id, weight, time, session_id, date)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		ON CONFLICT (user_id, workout_id, date) 
-		DO UPDATE SET weight = $3, time = $4`
+		DO UPDATE SET weight = $3, time = $4, session_id = COALESCE($5, user_progress.session_id),
+		              version = user_progress.version + 1, updated_at = CURRENT_TIMESTAMP`
 	
-	_, err := db.Exec(query, actualUserID, workoutID, update.UserWeight, update.UserTime)
+	tx, err := db.Begin()
 	if err != nil {
-		log.Printf("Error updating progress: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
 	
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
-}
-
-func (db *DB) GetUserProgress(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("user_id")
-	workoutID := r.URL.Query().Get("workout_id")
-	
-	// Get the actual user UUID
-	var actualUserID string
-	if userID != "" {
-		// First try to get user by email, then by ID
-		err := db.QueryRow("SELECT id FROM users WHERE email = $1 LIMIT 1", userID).Scan(&actualUserID)
-		if err != nil {
-			// If not found by email, try by ID
-			err = db.QueryRow("SELECT id FROM users WHERE id::text = $1 LIMIT 1", userID).Scan(&actualUserID)
-			if err != nil {
-				http.Error(w, "User not found", http.StatusBadRequest)
-				return
-			}
-		}
-	} else {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
+	// Lock the day's entry so If-Match is checked against what gets overwritten
+	existing, err := scanUserProgress(tx.QueryRow(`
+		SELECT `+progressColumns+` FROM user_progress up
+		WHERE up.user_id = $1 AND up.workout_id::text = $2 AND up.date = $3
+		FOR UPDATE`, actualUserID, workoutID, date))
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if r.Header.Get("If-Match") != "" {
+		if existing == nil {
+			http.Error(w, "Progress entry no longer exists", http.StatusConflict)
+			return
+		}
+		if !versionMatches(r, existing.Version) {
+			existing.inUnit(unit)
+			writeVersionConflict(w, existing.Version, existing)
+			return
+		}
+	}
 	
-	query := `
-		SELECT weight, time, date 
-		FROM user_progress 
-		WHERE user_id = $1 AND workout_id = $2
-		ORDER BY date DESC
-		LIMIT 10`
+	_, err = tx.Exec(query, actualUserID, workoutID, update.UserWeight, update.UserTime, sessionID, date)
+	if err != nil {
+		log.Printf("Error updating progress: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	
-	rows, err := db.Query(query, actualUserID, workoutID)
+	// Flag any personal bests so the client can celebrate them
+	records, err := detectRecords(tx, actualUserID, workoutID, date)
 	if err != nil {
+		log.Printf("Error detecting records: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	progress, err := scanUserProgress(tx.QueryRow(`
+		SELECT `+progressColumns+` FROM user_progress up
+		WHERE up.user_id = $1 AND up.workout_id::text = $2 AND up.date = $3`, actualUserID, workoutID, date))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 	
-	var progress []map[string]interface{}
-	for rows.Next() {
-		var weight sql.NullFloat64
-		var time sql.NullInt64
-		var date string
-		
-		err := rows.Scan(&weight, &time, &date)
-		if err != nil {
-			continue
-		}
-		
-		p := map[string]interface{}{
-			"date": date,
-		}
-		if weight.Valid {
-			p["weight"] = weight.Float64
-		}
-		if time.Valid {
-			p["time"] = time.Int64
-		}
-		
-		progress = append(progress, p)
+	progress.inUnit(unit)
+	for i := range records {
+		records[i].inUnit(unit)
 	}
 	
+	setETag(w, progress.Version)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(progress)
-}
\ No newline at end of file
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "records": records, "progress": progress})
+}
@@ -0,0 +1,354 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	// insightWindow is how many recent sessions are checked for progress
+	insightWindow = 4
+	// insightRegression is the drop from the earlier best that counts as
+	// a regression rather than a bad day
+	insightRegression = 0.05
+	// Exercises not logged for this long are no longer analysed
+	insightStaleAfter = 8 * 7 * 24 * time.Hour
+)
+
+// exerciseDay is a user's best effort at an exercise on one day.
+type exerciseDay struct {
+	date   string
+	value  float64
+	weight *float64
+}
+
+// exerciseHistory groups a user's logged performances by exercise and day.
+type exerciseHistory struct {
+	id, name string
+	days     map[string][]performance
+	order    []string
+}
+
+// loadExerciseHistories returns every exercise a user has logged, with the
+// performances of each day in date order.
+func (db *DB) loadExerciseHistories(userID string) ([]*exerciseHistory, error) {
+	rows, err := db.Query(`
+		SELECT p.exercise_id, e.name, p.date::text, p.weight, p.reps, p.duration
+		FROM `+loggedPerformances+`
+		JOIN exercises e ON e.id = p.exercise_id
+		WHERE p.user_id = $1
+		ORDER BY e.name, p.exercise_id, p.date`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var histories []*exerciseHistory
+	for rows.Next() {
+		var exerciseID, name, date string
+		p, err := scanPerformance(rows, &exerciseID, &name, &date)
+		if err != nil {
+			return nil, err
+		}
+		if len(histories) == 0 || histories[len(histories)-1].id != exerciseID {
+			histories = append(histories, &exerciseHistory{id: exerciseID, name: name, days: map[string][]performance{}})
+		}
+		h := histories[len(histories)-1]
+		if _, ok := h.days[date]; !ok {
+			h.order = append(h.order, date)
+		}
+		h.days[date] = append(h.days[date], p)
+	}
+	return histories, rows.Err()
+}
+
+// series reduces a history to one value per day: the best estimated 1RM
+// for weighted lifts, or the longest duration for timed exercises.
+func (h *exerciseHistory) series() (string, []exerciseDay) {
+	var e1rm, timed []exerciseDay
+	for _, date := range h.order {
+		b := bestOf(h.days[date])
+		if b.e1rm != nil {
+			e1rm = append(e1rm, exerciseDay{date: date, value: *b.e1rm, weight: b.weight})
+		}
+		if b.duration != nil {
+			timed = append(timed, exerciseDay{date: date, value: float64(*b.duration)})
+		}
+	}
+	if len(e1rm) > 0 {
+		return "e1rm", e1rm
+	}
+	return "time", timed
+}
+
+// roundToPlate rounds a weight down to the nearest 2.5, in whichever unit
+// it is given.
+func roundToPlate(weight float64) float64 {
+	return math.Floor(weight/2.5) * 2.5
+}
+
+// deload suggests a lighter working weight, or nil for timed exercises.
+func deload(day exerciseDay, factor float64) *float64 {
+	if day.weight == nil {
+		return nil
+	}
+	w := roundToPlate(*day.weight * factor)
+	return &w
+}
+
+// analyzeExercise flags an exercise whose recent sessions have stalled or
+// are declining. It needs more than insightWindow sessions, so there is an
+// earlier best to compare with; it returns nil when progress is fine.
+func analyzeExercise(metric string, days []exerciseDay) *Insight {
+	if len(days) <= insightWindow {
+		return nil
+	}
+	earlier, recent := days[:len(days)-insightWindow], days[len(days)-insightWindow:]
+	latest := recent[len(recent)-1]
+
+	// The first session to reach the best counts, so matching it is not progress
+	best, bestIndex := 0.0, 0
+	for i, d := range days {
+		if d.value > best {
+			best, bestIndex = d.value, i
+		}
+	}
+	if bestIndex >= len(earlier) {
+		return nil
+	}
+
+	insight := &Insight{
+		Metric:        metric,
+		Sessions:      len(days) - 1 - bestIndex,
+		Best:          round1(best),
+		Latest:        round1(latest.value),
+		ChangePercent: round1((latest.value - best) / best * 100),
+	}
+
+	// slopePerWeek only reads Date and E1RM
+	points := make([]StrengthPoint, len(recent))
+	for i, d := range recent {
+		points[i] = StrengthPoint{Date: d.date, E1RM: d.value}
+	}
+	declining := slopePerWeek(points) < 0 && latest.value < best*(1-insightRegression)
+
+	switch {
+	case declining:
+		insight.Kind = InsightRegression
+		insight.SuggestedWeight = deload(latest, 0.85)
+	case insight.Sessions >= 2*insightWindow:
+		insight.Kind = InsightPlateau
+	default:
+		insight.Kind = InsightPlateau
+		insight.SuggestedWeight = deload(latest, 0.9)
+	}
+	insight.Suggestion = insight.suggestion(Pounds)
+	return insight
+}
+
+// suggestion describes what to do about an insight, with any suggested
+// weight given in u.
+func (in *Insight) suggestion(u WeightUnit) string {
+	switch {
+	case in.Kind == InsightRegression && in.SuggestedWeight != nil:
+		return fmt.Sprintf("Performance is down %.1f%% from your best. Deload to %g %s for a week, "+
+			"then build back up; check sleep, food and recovery.", -in.ChangePercent, *in.SuggestedWeight, u)
+	case in.Kind == InsightRegression:
+		return fmt.Sprintf("Performance is down %.1f%% from your best. Take an easier week, "+
+			"then build back up; check sleep, food and recovery.", -in.ChangePercent)
+	case in.Sessions >= 2*insightWindow:
+		return fmt.Sprintf("No new best in %d sessions. Swap in a variation for a few weeks "+
+			"(different grip, stance, tempo or rep range) before returning to it.", in.Sessions)
+	case in.SuggestedWeight != nil:
+		return fmt.Sprintf("No new best in %d sessions. Deload to %g %s and work back up "+
+			"in smaller steps.", in.Sessions, *in.SuggestedWeight, u)
+	default:
+		return fmt.Sprintf("No new best in %d sessions. Cut back for a session or two, "+
+			"then add time in smaller steps.", in.Sessions)
+	}
+}
+
+// AnalyzeUserInsights rescans a user's history and replaces their stored
+// insights. An insight that is still present keeps its detected_at.
+func (db *DB) AnalyzeUserInsights(userID string) error {
+	histories, err := db.loadExerciseHistories(userID)
+	if err != nil {
+		return err
+	}
+
+	var insights []*Insight
+	cutoff := time.Now().Add(-insightStaleAfter).Format(dateLayout)
+	for _, h := range histories {
+		metric, days := h.series()
+		if len(days) == 0 || days[len(days)-1].date < cutoff {
+			continue
+		}
+		if insight := analyzeExercise(metric, days); insight != nil {
+			insight.ExerciseID = h.id
+			insights = append(insights, insight)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids := []string{}
+	for _, in := range insights {
+		var id string
+		err := tx.QueryRow(`
+			INSERT INTO insights (user_id, exercise_id, kind, metric, sessions, best, latest, change_percent,
+			                      suggestion, suggested_weight)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			ON CONFLICT (user_id, exercise_id, kind) DO UPDATE SET
+				metric = EXCLUDED.metric, sessions = EXCLUDED.sessions, best = EXCLUDED.best,
+				latest = EXCLUDED.latest, change_percent = EXCLUDED.change_percent,
+				suggestion = EXCLUDED.suggestion, suggested_weight = EXCLUDED.suggested_weight,
+				updated_at = CURRENT_TIMESTAMP
+			RETURNING id`,
+			userID, in.ExerciseID, in.Kind, in.Metric, in.Sessions, in.Best, in.Latest, in.ChangePercent,
+			in.Suggestion, in.SuggestedWeight).Scan(&id)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	// Exercises that are moving again lose their insight
+	if _, err := tx.Exec(`DELETE FROM insights WHERE user_id = $1 AND NOT (id::text = ANY($2))`,
+		userID, pq.Array(ids)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AnalyzeAllInsights refreshes the insights of every user. A failure for
+// one user is logged and does not stop the others.
+func (db *DB) AnalyzeAllInsights() error {
+	rows, err := db.Query(`SELECT id FROM users`)
+	if err != nil {
+		return err
+	}
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range userIDs {
+		if err := db.AnalyzeUserInsights(id); err != nil {
+			log.Printf("Error analysing insights for user %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// StartInsightsWorker analyses every user's history in the background,
+// once at startup and then every interval (a Go duration such as "6h",
+// default 6h). An interval of "0" disables the worker. The analysis is
+// idempotent, so running it on several replicas is harmless.
+func (db *DB) StartInsightsWorker(interval string) {
+	every := 6 * time.Hour
+	if interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			log.Printf("Invalid insights interval %q, using %s: %v", interval, every, err)
+		} else {
+			every = d
+		}
+	}
+	if every <= 0 {
+		log.Println("Insights worker disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			if err := db.AnalyzeAllInsights(); err != nil {
+				log.Printf("Error analysing insights: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// GetUserInsights lists the exercises a user is stuck on, regressions
+// first. The list is kept up to date by the background worker; with
+// refresh=true the user's history is analysed again before responding.
+// Optional filter: kind (plateau or regression).
+func (db *DB) GetUserInsights(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+	params := r.URL.Query()
+
+	kind := params.Get("kind")
+	if kind != "" && kind != string(InsightPlateau) && kind != string(InsightRegression) {
+		http.Error(w, "kind must be plateau or regression", http.StatusBadRequest)
+		return
+	}
+	if params.Get("refresh") == "true" {
+		if err := db.AnalyzeUserInsights(userID); err != nil {
+			log.Printf("Error analysing insights: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	rows, err := db.Query(`
+		SELECT i.id, i.user_id, i.exercise_id, e.name, i.kind, i.metric, i.sessions, i.best, i.latest,
+		       i.change_percent, i.suggestion, i.suggested_weight, i.detected_at, i.updated_at
+		FROM insights i
+		JOIN exercises e ON e.id = i.exercise_id
+		WHERE i.user_id = $1 AND ($2 = '' OR i.kind = $2)
+		ORDER BY i.kind = 'regression' DESC, i.change_percent, e.name`, userID, kind)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	insights := []Insight{}
+	for rows.Next() {
+		var in Insight
+		var suggested sql.NullFloat64
+		err := rows.Scan(&in.ID, &in.UserID, &in.ExerciseID, &in.ExerciseName, &in.Kind, &in.Metric,
+			&in.Sessions, &in.Best, &in.Latest, &in.ChangePercent, &in.Suggestion, &suggested,
+			&in.DetectedAt, &in.UpdatedAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if suggested.Valid {
+			in.SuggestedWeight = &suggested.Float64
+		}
+		in.inUnit(unit)
+		insights = append(insights, in)
+	}
+
+	writeJSON(w, http.StatusOK, insights)
+}
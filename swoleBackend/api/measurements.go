@@ -0,0 +1,447 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// bodyMetricColumns lists the metrics of a measurement in column order.
+var bodyMetricColumns = []string{"bodyweight", "body_fat", "neck", "chest", "waist", "hips", "arm", "thigh", "calf"}
+
+const measurementQuery = `
+	SELECT id, user_id, date::text, bodyweight, body_fat, neck, chest, waist, hips, arm, thigh, calf,
+	       notes, created_at, updated_at
+	FROM body_measurements`
+
+// values returns pointers to each metric, in the order of bodyMetricColumns.
+func (m *BodyMetrics) values() []**float64 {
+	return []**float64{&m.Bodyweight, &m.BodyFat, &m.Neck, &m.Chest, &m.Waist, &m.Hips, &m.Arm, &m.Thigh, &m.Calf}
+}
+
+// args returns the metrics as query arguments, in column order.
+func (m *BodyMetrics) args() []interface{} {
+	var args []interface{}
+	for _, v := range m.values() {
+		args = append(args, *v)
+	}
+	return args
+}
+
+// validate checks that at least one metric is set and all are in range.
+func (m *BodyMetrics) validate() error {
+	empty := true
+	for i, v := range m.values() {
+		if *v == nil {
+			continue
+		}
+		empty = false
+		if **v <= 0 {
+			return fmt.Errorf("%s must be positive", bodyMetricColumns[i])
+		}
+	}
+	if empty {
+		return fmt.Errorf("at least one of %v is required", bodyMetricColumns)
+	}
+	if m.BodyFat != nil && *m.BodyFat >= 100 {
+		return fmt.Errorf("body_fat must be a percentage below 100")
+	}
+	return nil
+}
+
+func scanMeasurement(row interface{ Scan(...interface{}) error }) (*BodyMeasurement, error) {
+	var m BodyMeasurement
+	metrics := make([]sql.NullFloat64, len(bodyMetricColumns))
+	var notes sql.NullString
+
+	dest := []interface{}{&m.ID, &m.UserID, &m.Date}
+	for i := range metrics {
+		dest = append(dest, &metrics[i])
+	}
+	dest = append(dest, &notes, &m.CreatedAt, &m.UpdatedAt)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	for i, v := range m.values() {
+		if metrics[i].Valid {
+			value := metrics[i].Float64
+			*v = &value
+		}
+	}
+	m.Notes = nullStringPtr(notes)
+	return &m, nil
+}
+
+// requireOwnMeasurement loads a measurement for a change by userID,
+// writing a 404 or 403 response and returning false if it can't be.
+func (db *DB) requireOwnMeasurement(w http.ResponseWriter, measurementID, userID string) (*BodyMeasurement, bool) {
+	m, err := scanMeasurement(db.QueryRow(measurementQuery+` WHERE id::text = $1`, measurementID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Measurement not found", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if m.UserID != userID {
+		http.Error(w, "Measurement belongs to another user", http.StatusForbidden)
+		return nil, false
+	}
+	return m, true
+}
+
+// CreateMeasurement records a user's measurements for a day (default
+// today in their timezone). A day holds one measurement; if the day is
+// already taken the existing one is returned with 409.
+func (db *DB) CreateMeasurement(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Date     string  `json:"date"`
+		Timezone string  `json:"timezone"`
+		Notes    *string `json:"notes"`
+		BodyMetrics
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := body.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	date, ok := db.requireLogDate(w, userID, body.Timezone, body.Date, nil)
+	if !ok {
+		return
+	}
+	body.Bodyweight = unit.pounds(body.Bodyweight)
+
+	var id string
+	args := append([]interface{}{userID, date, body.Notes}, body.args()...)
+	err := db.QueryRow(`
+		INSERT INTO body_measurements (user_id, date, notes, bodyweight, body_fat, neck, chest, waist, hips, arm, thigh, calf)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (user_id, date) DO NOTHING
+		RETURNING id`, args...).Scan(&id)
+	if err == sql.ErrNoRows {
+		existing, err := scanMeasurement(db.QueryRow(measurementQuery+` WHERE user_id = $1 AND date = $2`, userID, date))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		existing.inUnit(unit)
+		writeJSON(w, http.StatusConflict, existing)
+		return
+	} else if err != nil {
+		log.Printf("Error creating measurement: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	m, err := scanMeasurement(db.QueryRow(measurementQuery+` WHERE id = $1`, id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	m.inUnit(unit)
+	writeJSON(w, http.StatusCreated, m)
+}
+
+func (db *DB) GetMeasurement(w http.ResponseWriter, r *http.Request) {
+	m, err := scanMeasurement(db.QueryRow(measurementQuery+` WHERE id::text = $1`, mux.Vars(r)["id"]))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Measurement not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	unit, ok := db.requireUnit(w, r, m.UserID)
+	if !ok {
+		return
+	}
+	m.inUnit(unit)
+	writeJSON(w, http.StatusOK, m)
+}
+
+// GetMeasurements lists a user's measurements, newest first. Optional
+// from/to (YYYY-MM-DD, inclusive) bound the date; limit defaults to 100.
+func (db *DB) GetMeasurements(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+	params := r.URL.Query()
+
+	limit := 100
+	if l := params.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 1000 {
+			http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if !validDateRange(w, params) {
+		return
+	}
+
+	rows, err := db.Query(measurementQuery+`
+		WHERE user_id = $1
+		  AND ($2 = '' OR date >= NULLIF($2, '')::date)
+		  AND ($3 = '' OR date <= NULLIF($3, '')::date)
+		ORDER BY date DESC
+		LIMIT $4`, userID, params.Get("from"), params.Get("to"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	measurements := []BodyMeasurement{}
+	for rows.Next() {
+		m, err := scanMeasurement(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		m.inUnit(unit)
+		measurements = append(measurements, *m)
+	}
+	writeJSON(w, http.StatusOK, measurements)
+}
+
+// UpdateMeasurement changes a measurement. Omitted fields are kept and
+// fields sent as null are cleared; moving it to a day that already has a
+// measurement returns 409.
+func (db *DB) UpdateMeasurement(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	current, ok := db.requireOwnMeasurement(w, mux.Vars(r)["id"], userID)
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+	shown := current.BodyMetrics
+	shown.inUnit(unit)
+
+	body := struct {
+		Date     string  `json:"date"`
+		Timezone string  `json:"timezone"`
+		Notes    *string `json:"notes"`
+		BodyMetrics
+	}{Date: current.Date, Notes: current.Notes, BodyMetrics: shown}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := body.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	date, ok := db.requireLogDate(w, userID, body.Timezone, body.Date, nil)
+	if !ok {
+		return
+	}
+	body.Bodyweight = unit.edited(body.Bodyweight, current.Bodyweight)
+
+	args := append([]interface{}{current.ID, date, body.Notes}, body.args()...)
+	_, err := db.Exec(`
+		UPDATE body_measurements
+		SET date = $2, notes = $3, bodyweight = $4, body_fat = $5, neck = $6, chest = $7, waist = $8,
+		    hips = $9, arm = $10, thigh = $11, calf = $12, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, args...)
+	if isUniqueViolation(err) {
+		http.Error(w, "Another measurement exists on "+date, http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Printf("Error updating measurement: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	m, err := scanMeasurement(db.QueryRow(measurementQuery+` WHERE id = $1`, current.ID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	m.inUnit(unit)
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (db *DB) DeleteMeasurement(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	current, ok := db.requireOwnMeasurement(w, mux.Vars(r)["id"], userID)
+	if !ok {
+		return
+	}
+
+	if _, err := db.Exec(`DELETE FROM body_measurements WHERE id = $1`, current.ID); err != nil {
+		log.Printf("Error deleting measurement: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetMeasurementSeries returns the history of one metric (default
+// bodyweight) in date order, with a rolling trend and rate of change.
+// Optional parameters: from/to and window (measurements averaged for the
+// trend, default 7).
+func (db *DB) GetMeasurementSeries(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+	params := r.URL.Query()
+
+	metric := params.Get("metric")
+	if metric == "" {
+		metric = "bodyweight"
+	}
+	// Only a known column name is put into the query
+	column := -1
+	for i, c := range bodyMetricColumns {
+		if c == metric {
+			column = i
+		}
+	}
+	if column < 0 {
+		http.Error(w, fmt.Sprintf("metric must be one of %v", bodyMetricColumns), http.StatusBadRequest)
+		return
+	}
+
+	window := 7
+	if v := params.Get("window"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 90 {
+			http.Error(w, "window must be between 1 and 90", http.StatusBadRequest)
+			return
+		}
+		window = n
+	}
+	if !validDateRange(w, params) {
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT date::text, `+bodyMetricColumns[column]+` FROM body_measurements
+		WHERE user_id = $1 AND `+bodyMetricColumns[column]+` IS NOT NULL
+		  AND ($2 = '' OR date >= NULLIF($2, '')::date)
+		  AND ($3 = '' OR date <= NULLIF($3, '')::date)
+		ORDER BY date`, userID, params.Get("from"), params.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	series := BodyMetricSeries{UserID: userID, Metric: metric, Window: window, Points: []BodyMetricPoint{}}
+	var sum float64
+	for rows.Next() {
+		var p BodyMetricPoint
+		if err := rows.Scan(&p.Date, &p.Value); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		i := len(series.Points)
+		sum += p.Value
+		if i >= window {
+			sum -= series.Points[i-window].Value
+		}
+		p.Trend = round1(sum / float64(min(i+1, window)))
+		series.Points = append(series.Points, p)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if n := len(series.Points); n > 0 {
+		latest := series.Points[n-1].Value
+		series.Latest = &latest
+		series.Change = round1(latest - series.Points[0].Value)
+
+		// slopePerWeek only reads Date and E1RM
+		points := make([]StrengthPoint, n)
+		for i, p := range series.Points {
+			points[i] = StrengthPoint{Date: p.Date, E1RM: p.Value}
+		}
+		series.ChangePerWeek = round1(slopePerWeek(points))
+	}
+	series.inUnit(unit)
+	writeJSON(w, http.StatusOK, series)
+}
+
+// weighIn is a user's bodyweight on a day.
+type weighIn struct {
+	date   string
+	weight float64
+}
+
+// loadWeighIns returns a user's recorded bodyweights in date order.
+func (db *DB) loadWeighIns(userID string) ([]weighIn, error) {
+	rows, err := db.Query(`
+		SELECT date::text, bodyweight FROM body_measurements
+		WHERE user_id = $1 AND bodyweight IS NOT NULL
+		ORDER BY date`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var weighIns []weighIn
+	for rows.Next() {
+		var wi weighIn
+		if err := rows.Scan(&wi.date, &wi.weight); err != nil {
+			return nil, err
+		}
+		weighIns = append(weighIns, wi)
+	}
+	return weighIns, rows.Err()
+}
+
+// bodyweightOn returns the latest bodyweight recorded on or before date,
+// falling back to the first weigh-in for days before it. It returns nil
+// when there are no weigh-ins.
+func bodyweightOn(weighIns []weighIn, date string) *float64 {
+	if len(weighIns) == 0 {
+		return nil
+	}
+	// YYYY-MM-DD strings compare in calendar order
+	i := sort.Search(len(weighIns), func(i int) bool { return weighIns[i].date > date })
+	if i > 0 {
+		i--
+	}
+	return &weighIns[i].weight
+}
@@ -12,42 +12,163 @@ const (
 	LowerBody WorkoutType = "lower_body"
 	UpperBody WorkoutType = "upper_body"
 	Abs       WorkoutType = "abs"
-	
+
 	Lift     ExerciseType = "lift"
 	Timed    ExerciseType = "timed"
 	Class    ExerciseType = "class"
 	Activity ExerciseType = "activity"
 )
 
+// Valid reports whether t is one of the known workout types.
+func (t WorkoutType) Valid() bool {
+	switch t {
+	case LowerBody, UpperBody, Abs:
+		return true
+	}
+	return false
+}
+
+// Valid reports whether t is one of the known exercise types.
+func (t ExerciseType) Valid() bool {
+	switch t {
+	case Lift, Timed, Class, Activity:
+		return true
+	}
+	return false
+}
+
 type Workout struct {
-	ID           string         `json:"id"`
-	Name         string         `json:"name"`
-	Type         *WorkoutType   `json:"type"`
-	ExerciseType ExerciseType   `json:"exerciseType"`
-	Weight       *float64       `json:"weight,omitempty"`
-	Time         *int           `json:"time,omitempty"`
-	Reps         *int           `json:"reps,omitempty"`
-	Sets         *int           `json:"sets,omitempty"`
-	Description  *string        `json:"description,omitempty"`
-	UserWeight   *float64       `json:"userWeight,omitempty"`
-	UserTime     *int           `json:"userTime,omitempty"`
-	RoutineID    string         `json:"routine_id"`
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
+	ID           string           `json:"id"`
+	ExerciseID   *string          `json:"exerciseId,omitempty"`
+	Name         string           `json:"name"`
+	Type         *WorkoutType     `json:"type"`
+	ExerciseType ExerciseType     `json:"exerciseType"`
+	Weight       *float64         `json:"weight,omitempty"`
+	Time         *int             `json:"time,omitempty"`
+	Reps         *int             `json:"reps,omitempty"`
+	Sets         *int             `json:"sets,omitempty"`
+	Description  *string          `json:"description,omitempty"`
+	Instructions *string          `json:"instructions,omitempty"`
+	MuscleGroups []string         `json:"muscleGroups"`
+	Progression  *ProgressionRule `json:"progression,omitempty"`
+	UserWeight   *float64         `json:"userWeight,omitempty"`
+	UserTime     *int             `json:"userTime,omitempty"`
+	// Next-session prescription from Progression and the last logged session
+	SuggestedWeight *float64  `json:"suggestedWeight,omitempty"`
+	SuggestedReps   *int      `json:"suggestedReps,omitempty"`
+	SuggestedTime   *int      `json:"suggestedTime,omitempty"`
+	Position        int       `json:"position"`
+	RoutineID       string    `json:"routine_id"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
 }
 
 type Routine struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Description *string   `json:"description,omitempty"`
-	Workouts    []Workout `json:"workouts"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID              string    `json:"id"`
+	Name            string    `json:"name"`
+	Description     *string   `json:"description,omitempty"`
+	Category        *string   `json:"category,omitempty"`
+	Difficulty      *string   `json:"difficulty,omitempty"`
+	DurationMinutes *int      `json:"durationMinutes,omitempty"`
+	Workouts        []Workout `json:"workouts"`
+	// Set when a recurrence rather than the week schedule planned the routine
+	RecurrenceID *string   `json:"recurrence_id,omitempty"`
+	Version      int       `json:"version"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// WorkoutInput is the writable subset of a Workout accepted by the
+// create/update endpoints.
+type WorkoutInput struct {
+	ExerciseID   *string          `json:"exerciseId"`
+	Name         string           `json:"name"`
+	Type         *WorkoutType     `json:"type"`
+	ExerciseType ExerciseType     `json:"exerciseType"`
+	Weight       *float64         `json:"weight"`
+	Time         *int             `json:"time"`
+	Reps         *int             `json:"reps"`
+	Sets         *int             `json:"sets"`
+	Description  *string          `json:"description"`
+	Instructions *string          `json:"instructions"`
+	MuscleGroups []string         `json:"muscleGroups"`
+	Progression  *ProgressionRule `json:"progression"`
+}
+
+type ProgressionType string
+
+const (
+	LinearProgression ProgressionType = "linear"
+	DoubleProgression ProgressionType = "double"
+	TimeProgression   ProgressionType = "time"
+)
+
+// ProgressionRule declares how a workout's prescription moves from one
+// session to the next:
+//   - linear: add WeightIncrement once every prescribed set hits the
+//     workout's reps, e.g. "add 5 lb when all sets hit 8 reps"
+//   - double: add RepIncrement (default 1) each session until MaxReps, then
+//     add WeightIncrement and drop back to MinReps (default: the workout's
+//     reps), e.g. "add 1 rep until 12, then add weight"
+//   - time: add TimeIncrement seconds once the workout's time is reached
+type ProgressionRule struct {
+	Type            ProgressionType `json:"type" yaml:"type"`
+	WeightIncrement *float64        `json:"weightIncrement,omitempty" yaml:"weight_increment"`
+	RepIncrement    *int            `json:"repIncrement,omitempty" yaml:"rep_increment"`
+	MinReps         *int            `json:"minReps,omitempty" yaml:"min_reps"`
+	MaxReps         *int            `json:"maxReps,omitempty" yaml:"max_reps"`
+	TimeIncrement   *int            `json:"timeIncrement,omitempty" yaml:"time_increment"`
+}
+
+// RoutineInput is the writable subset of a Routine. Workouts are only
+// honoured when creating a routine; afterwards they are managed through
+// the nested workout endpoints.
+type RoutineInput struct {
+	Name            string         `json:"name"`
+	Description     *string        `json:"description"`
+	Category        *string        `json:"category"`
+	Difficulty      *string        `json:"difficulty"`
+	DurationMinutes *int           `json:"durationMinutes"`
+	Workouts        []WorkoutInput `json:"workouts,omitempty"`
+}
+
+// Exercise is a catalog entry shared by every routine workout that
+// performs the same movement, e.g. "Bench Press" in two routines.
+type Exercise struct {
+	ID           string       `json:"id"`
+	Slug         string       `json:"slug"`
+	Name         string       `json:"name"`
+	Type         *WorkoutType `json:"type"`
+	ExerciseType ExerciseType `json:"exerciseType"`
+	MuscleGroups []string     `json:"muscleGroups"`
+	RoutineCount int          `json:"routineCount"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+}
+
+// ExerciseHistoryEntry is one logged day of an exercise, tagged with the
+// routine workout it was logged against.
+type ExerciseHistoryEntry struct {
+	Date        string   `json:"date"`
+	Weight      *float64 `json:"weight,omitempty"`
+	Time        *int     `json:"time,omitempty"`
+	WorkoutID   string   `json:"workout_id"`
+	RoutineID   string   `json:"routine_id"`
+	RoutineName string   `json:"routine_name"`
+}
+
+type ExerciseHistory struct {
+	Exercise   Exercise               `json:"exercise"`
+	UserID     string                 `json:"user_id"`
+	BestWeight *float64               `json:"bestWeight,omitempty"`
+	BestTime   *int                   `json:"bestTime,omitempty"`
+	Entries    []ExerciseHistoryEntry `json:"entries"`
 }
 
 type DaySchedule struct {
 	ID        string    `json:"id"`
 	Day       string    `json:"day"`
+	Date      string    `json:"date,omitempty"`
 	WeekID    string    `json:"week_id"`
 	Routines  []Routine `json:"routines"`
 	CreatedAt time.Time `json:"created_at"`
@@ -59,28 +180,549 @@ type WeekSchedule struct {
 	UserID    string        `json:"user_id"`
 	WeekStart time.Time     `json:"week_start"`
 	Schedule  []DaySchedule `json:"schedule"`
-	CreatedAt time.Time     `json:"created_at"`
-	UpdatedAt time.Time     `json:"updated_at"`
+	// Set when the week comes from a program the user is enrolled in
+	Program   *ScheduledProgram `json:"program,omitempty"`
+	Version   int               `json:"version"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// Program is a multi-week training block. Each week plans routines on
+// weekdays like a week schedule, keyed by day name, and can scale the
+// prescriptions of its workouts.
+type Program struct {
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	Description *string       `json:"description,omitempty"`
+	Weeks       []ProgramWeek `json:"weeks"`
+	Version     int           `json:"version"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+// ProgramWeek is one week of a program, numbered from 1. LoadPercent
+// scales prescribed weights (100 leaves them as they are) and RepChange is
+// added to prescribed reps.
+type ProgramWeek struct {
+	Number      int                 `json:"number"`
+	Name        *string             `json:"name,omitempty"`
+	LoadPercent float64             `json:"load_percent"`
+	RepChange   int                 `json:"rep_change"`
+	Schedule    map[string][]string `json:"schedule"`
+}
+
+// ProgramInput is the writable part of a Program. Weeks are numbered in
+// the order they are listed.
+type ProgramInput struct {
+	Name        string        `json:"name"`
+	Description *string       `json:"description"`
+	Weeks       []ProgramWeek `json:"weeks"`
+}
+
+// ProgramEnrollment puts a user on a program from the week of StartDate.
+// EndDate is the Sunday of the program's last week.
+type ProgramEnrollment struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	ProgramID   string    `json:"program_id"`
+	ProgramName string    `json:"program_name"`
+	StartDate   string    `json:"start_date"`
+	EndDate     string    `json:"end_date"`
+	Weeks       int       `json:"weeks"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Rotation is an ordered list of routines a user cycles through
+// regardless of weekday, such as push/pull/legs. A routine may appear more
+// than once. Position is the index of the routine that is up next.
+type Rotation struct {
+	ID        string            `json:"id"`
+	UserID    string            `json:"user_id"`
+	Name      *string           `json:"name,omitempty"`
+	Routines  []RotationRoutine `json:"routines"`
+	Position  int               `json:"position"`
+	Version   int               `json:"version"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+type RotationRoutine struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// RotationNext is the routine up next in a user's rotation, with the
+// user's progress for the day filled in.
+type RotationNext struct {
+	Position int     `json:"position"`
+	Length   int     `json:"length"`
+	Routine  Routine `json:"routine"`
+}
+
+// RoutineRecurrence plans a routine on the days an RFC 5545 RRULE yields
+// from StartDate, except the dates in Exceptions.
+type RoutineRecurrence struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	RoutineID   string    `json:"routine_id"`
+	RoutineName string    `json:"routine_name"`
+	RRule       string    `json:"rrule"`
+	StartDate   string    `json:"start_date"`
+	Exceptions  []string  `json:"exceptions"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Occurrence is a day a recurrence plans its routine on.
+type Occurrence struct {
+	Date         string `json:"date"`
+	RecurrenceID string `json:"recurrence_id"`
+	RoutineID    string `json:"routine_id"`
+	RoutineName  string `json:"routine_name"`
+}
+
+// ScheduledProgram is the program week a week schedule was taken from.
+type ScheduledProgram struct {
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	EnrollmentID string  `json:"enrollment_id"`
+	Week         int     `json:"week"`
+	Weeks        int     `json:"weeks"`
+	WeekName     *string `json:"week_name,omitempty"`
+	LoadPercent  float64 `json:"load_percent"`
+	RepChange    int     `json:"rep_change"`
+}
+
+// WeightUnit is the unit weights are shown and entered in. They are always
+// stored in pounds.
+type WeightUnit string
+
+const (
+	Pounds    WeightUnit = "lb"
+	Kilograms WeightUnit = "kg"
+)
+
+// Valid reports whether u is one of the supported units.
+func (u WeightUnit) Valid() bool {
+	return u == Pounds || u == Kilograms
 }
 
 type User struct {
+	ID         string     `json:"id"`
+	Email      string     `json:"email"`
+	Name       string     `json:"name"`
+	Timezone   string     `json:"timezone"`
+	WeightUnit WeightUnit `json:"weight_unit"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+type UserProgress struct {
 	ID        string    `json:"id"`
-	Email     string    `json:"email"`
-	Name      string    `json:"name"`
+	UserID    string    `json:"user_id"`
+	WorkoutID string    `json:"workout_id"`
+	SessionID *string   `json:"session_id,omitempty"`
+	Weight    *float64  `json:"weight,omitempty"`
+	Time      *int      `json:"time,omitempty"`
+	Date      string    `json:"date"`
+	Version   int       `json:"version"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-type UserProgress struct {
+type RecordType string
+
+const (
+	RecordWeight RecordType = "weight"
+	RecordTime   RecordType = "time"
+	RecordE1RM   RecordType = "e1rm"
+	RecordRepMax RecordType = "rep_max"
+)
+
+// Valid reports whether t is one of the known record types.
+func (t RecordType) Valid() bool {
+	switch t {
+	case RecordWeight, RecordTime, RecordE1RM, RecordRepMax:
+		return true
+	}
+	return false
+}
+
+// PersonalRecord is a personal best set on Date. Value is the new best
+// (weight, seconds, estimated 1RM or reps, depending on Type); Weight and
+// Reps describe the performance behind it. For rep_max, Value is the reps
+// done at Weight and PreviousValue the most reps done before at that weight
+// or heavier.
+type PersonalRecord struct {
+	ID            string     `json:"id"`
+	UserID        string     `json:"user_id"`
+	ExerciseID    string     `json:"exercise_id"`
+	ExerciseName  string     `json:"exercise_name,omitempty"`
+	WorkoutID     string     `json:"workout_id"`
+	Type          RecordType `json:"type"`
+	Value         float64    `json:"value"`
+	PreviousValue *float64   `json:"previous_value,omitempty"`
+	Weight        *float64   `json:"weight,omitempty"`
+	Reps          *int       `json:"reps,omitempty"`
+	Date          string     `json:"date"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// OneRepMaxFormula selects how a one-rep max is estimated from a set.
+type OneRepMaxFormula string
+
+const (
+	Epley   OneRepMaxFormula = "epley"
+	Brzycki OneRepMaxFormula = "brzycki"
+)
+
+// Valid reports whether f is one of the supported formulas.
+func (f OneRepMaxFormula) Valid() bool {
+	return f == Epley || f == Brzycki
+}
+
+// StrengthPoint is a day's best estimated one-rep max for an exercise and
+// the set it came from. Trend is the rolling average ending on that day.
+// Relative is the e1RM as a multiple of Bodyweight, the user's weigh-in on
+// or before that day; both are left out when the user has no weigh-ins.
+type StrengthPoint struct {
+	Date       string   `json:"date"`
+	E1RM       float64  `json:"e1rm"`
+	Weight     float64  `json:"weight"`
+	Reps       int      `json:"reps"`
+	Trend      float64  `json:"trend"`
+	Bodyweight *float64 `json:"bodyweight,omitempty"`
+	Relative   *float64 `json:"relative,omitempty"`
+}
+
+// StrengthTrend is the e1RM history of one exercise. ChangePerWeek is the
+// least-squares slope of e1RM over time; ChangePercent compares the first
+// and last trend values. RelativeBest and RelativeLatest are the best and
+// latest e1RM as a multiple of bodyweight.
+type StrengthTrend struct {
+	ExerciseID     string          `json:"exercise_id"`
+	ExerciseName   string          `json:"exercise_name"`
+	Points         []StrengthPoint `json:"points"`
+	Best           float64         `json:"best"`
+	Latest         float64         `json:"latest"`
+	ChangePerWeek  float64         `json:"change_per_week"`
+	ChangePercent  float64         `json:"change_percent"`
+	RelativeBest   *float64        `json:"relative_best,omitempty"`
+	RelativeLatest *float64        `json:"relative_latest,omitempty"`
+}
+
+type StrengthAnalytics struct {
+	UserID    string           `json:"user_id"`
+	Formula   OneRepMaxFormula `json:"formula"`
+	Window    int              `json:"window"`
+	Exercises []StrengthTrend  `json:"exercises"`
+}
+
+// BodyMetrics are the values of a body measurement; any of them may be
+// left out. Bodyweight is a weight like any other, BodyFat is a percentage
+// and the circumferences are in centimetres.
+type BodyMetrics struct {
+	Bodyweight *float64 `json:"bodyweight,omitempty"`
+	BodyFat    *float64 `json:"body_fat,omitempty"`
+	Neck       *float64 `json:"neck,omitempty"`
+	Chest      *float64 `json:"chest,omitempty"`
+	Waist      *float64 `json:"waist,omitempty"`
+	Hips       *float64 `json:"hips,omitempty"`
+	Arm        *float64 `json:"arm,omitempty"`
+	Thigh      *float64 `json:"thigh,omitempty"`
+	Calf       *float64 `json:"calf,omitempty"`
+}
+
+// BodyMeasurement is what a user measured on one day.
+type BodyMeasurement struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	Date   string `json:"date"`
+	BodyMetrics
+	Notes     *string   `json:"notes,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// BodyMetricPoint is one measured value of a metric. Trend is the rolling
+// average ending on that day.
+type BodyMetricPoint struct {
+	Date  string  `json:"date"`
+	Value float64 `json:"value"`
+	Trend float64 `json:"trend"`
+}
+
+// BodyMetricSeries is the history of one body metric, e.g. bodyweight.
+// Change is the difference between the first and latest value;
+// ChangePerWeek is the least-squares slope over time.
+type BodyMetricSeries struct {
+	UserID        string            `json:"user_id"`
+	Metric        string            `json:"metric"`
+	Window        int               `json:"window"`
+	Points        []BodyMetricPoint `json:"points"`
+	Latest        *float64          `json:"latest,omitempty"`
+	Change        float64           `json:"change"`
+	ChangePerWeek float64           `json:"change_per_week"`
+}
+
+// MuscleGroupVolume is the training volume for one muscle group. Tonnage
+// is weight x reps summed over working sets; HardSets counts the working
+// sets with no RPE logged or an RPE of 7 or more.
+type MuscleGroupVolume struct {
+	MuscleGroup string  `json:"muscle_group"`
+	Tonnage     float64 `json:"tonnage"`
+	Sets        int     `json:"sets"`
+	HardSets    int     `json:"hard_sets"`
+}
+
+type WeeklyVolume struct {
+	WeekStart    string              `json:"week_start"`
+	MuscleGroups []MuscleGroupVolume `json:"muscle_groups"`
+}
+
+type VolumeAnalytics struct {
+	UserID string         `json:"user_id"`
+	From   string         `json:"from"`
+	To     string         `json:"to"`
+	Weeks  []WeeklyVolume `json:"weeks"`
+	// Totals sums each muscle group over every week
+	Totals []MuscleGroupVolume `json:"totals"`
+}
+
+// WeeklyAdherence compares the days planned in a Monday-to-Sunday week
+// with the days on which the user logged one of the planned routines.
+type WeeklyAdherence struct {
+	WeekStart         string  `json:"week_start"`
+	Planned           int     `json:"planned"`
+	Completed         int     `json:"completed"`
+	CompletionPercent float64 `json:"completion_percent"`
+}
+
+// MissedDay is a scheduled training day with nothing logged.
+type MissedDay struct {
+	Date     string   `json:"date"`
+	Day      string   `json:"day"`
+	Routines []string `json:"routines"`
+}
+
+// Adherence summarises how closely a user followed their week schedule.
+// Streaks count consecutive completed training days; rest days neither
+// extend nor break them.
+type Adherence struct {
+	UserID            string            `json:"user_id"`
+	From              string            `json:"from"`
+	To                string            `json:"to"`
+	Weeks             []WeeklyAdherence `json:"weeks"`
+	CompletionPercent float64           `json:"completion_percent"`
+	CurrentStreak     int               `json:"current_streak"`
+	LongestStreak     int               `json:"longest_streak"`
+	Missed            []MissedDay       `json:"missed"`
+}
+
+// InsightKind classifies a stalled exercise.
+type InsightKind string
+
+const (
+	// InsightPlateau: no new best in the last few sessions
+	InsightPlateau InsightKind = "plateau"
+	// InsightRegression: performance is trending down from an earlier best
+	InsightRegression InsightKind = "regression"
+)
+
+// Insight flags an exercise a user is stuck on. Metric is "e1rm" for
+// weighted lifts and "time" (seconds) for timed ones; Best is the best
+// before the stall and Latest the most recent session. SuggestedWeight is
+// the deload target when one applies.
+type Insight struct {
+	ID              string      `json:"id"`
+	UserID          string      `json:"user_id"`
+	ExerciseID      string      `json:"exercise_id"`
+	ExerciseName    string      `json:"exercise_name"`
+	Kind            InsightKind `json:"kind"`
+	Metric          string      `json:"metric"`
+	Sessions        int         `json:"sessions"`
+	Best            float64     `json:"best"`
+	Latest          float64     `json:"latest"`
+	ChangePercent   float64     `json:"change_percent"`
+	Suggestion      string      `json:"suggestion"`
+	SuggestedWeight *float64    `json:"suggested_weight,omitempty"`
+	DetectedAt      time.Time   `json:"detected_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+}
+
+// ProgressPage is one page of GET /api/progress. NextCursor is set when
+// more entries follow.
+type ProgressPage struct {
+	Progress   []UserProgress `json:"progress"`
+	NextCursor *string        `json:"next_cursor,omitempty"`
+}
+
+// ProgressAction is a change recorded in the progress audit trail.
+type ProgressAction string
+
+const (
+	ProgressUpdated  ProgressAction = "update"
+	ProgressDeleted  ProgressAction = "delete"
+	ProgressRestored ProgressAction = "restore"
+)
+
+// ProgressSnapshot is a progress entry together with the sets logged for
+// it that day.
+type ProgressSnapshot struct {
+	Progress UserProgress `json:"progress"`
+	Sets     []WorkoutSet `json:"sets,omitempty"`
+}
+
+// ProgressAudit is one change to a progress entry. Snapshot is the entry
+// before an update or delete, or as it was brought back by a restore.
+// Deleted entries can be restored until ExpiresAt.
+type ProgressAudit struct {
+	ID         string           `json:"id"`
+	ProgressID string           `json:"progress_id"`
+	UserID     string           `json:"user_id"`
+	Action     ProgressAction   `json:"action"`
+	Snapshot   ProgressSnapshot `json:"snapshot"`
+	RestoredAt *time.Time       `json:"restored_at,omitempty"`
+	CreatedAt  time.Time        `json:"created_at"`
+	ExpiresAt  time.Time        `json:"expires_at"`
+}
+
+// SyncMutationType is a change queued by an offline client.
+type SyncMutationType string
+
+const (
+	SyncLogProgress    SyncMutationType = "progress.log"
+	SyncDeleteProgress SyncMutationType = "progress.delete"
+	SyncStartSession   SyncMutationType = "session.start"
+	SyncFinishSession  SyncMutationType = "session.finish"
+)
+
+// SyncMutation is one queued change in a POST /api/sync batch. ClientID is
+// generated by the client and makes the mutation idempotent; OccurredAt is
+// when it happened on the device. Which other fields apply depends on
+// Type. An entity created earlier by the same client can be referenced by
+// its client ID (SessionClientID, ProgressClientID) before the client
+// knows the server ID.
+type SyncMutation struct {
+	ClientID   string           `json:"client_id"`
+	Type       SyncMutationType `json:"type"`
+	OccurredAt *time.Time       `json:"occurred_at"`
+	// progress.log
+	WorkoutID  string   `json:"workout_id,omitempty"`
+	UserWeight *float64 `json:"userWeight,omitempty"`
+	UserTime   *int     `json:"userTime,omitempty"`
+	Date       string   `json:"date,omitempty"`
+	Timezone   string   `json:"timezone,omitempty"`
+	// progress.delete
+	ProgressID       string `json:"progress_id,omitempty"`
+	ProgressClientID string `json:"progress_client_id,omitempty"`
+	// session.start and session.finish; progress.log attaches to the session
+	SessionID       string     `json:"session_id,omitempty"`
+	SessionClientID string     `json:"session_client_id,omitempty"`
+	RoutineID       *string    `json:"routine_id,omitempty"`
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	EndedAt         *time.Time `json:"ended_at,omitempty"`
+	Notes           *string    `json:"notes,omitempty"`
+}
+
+// SyncStatus is the outcome of one mutation. Rejected mutations are
+// invalid and should be dropped; failed ones can be retried.
+type SyncStatus string
+
+const (
+	SyncApplied   SyncStatus = "applied"
+	SyncDuplicate SyncStatus = "duplicate"
+	// The server changed the entry after the mutation happened on the
+	// device, so the server's version was kept
+	SyncSuperseded SyncStatus = "superseded"
+	SyncRejected   SyncStatus = "rejected"
+	SyncFailed     SyncStatus = "failed"
+)
+
+// SyncResult reports what happened to one mutation. ID is the server ID of
+// the progress entry or session it created or changed.
+type SyncResult struct {
+	ClientID string           `json:"client_id"`
+	Status   SyncStatus       `json:"status"`
+	ID       string           `json:"id,omitempty"`
+	Error    string           `json:"error,omitempty"`
+	Records  []PersonalRecord `json:"records,omitempty"`
+}
+
+// SyncChanges is everything that changed on the server since the client's
+// sync token. Entries may repeat ones the client already has; apply them
+// by ID.
+type SyncChanges struct {
+	Progress        []UserProgress   `json:"progress"`
+	DeletedProgress []string         `json:"deleted_progress"`
+	Sessions        []WorkoutSession `json:"sessions"`
+}
+
+// SyncResponse answers POST /api/sync. When Reset is true the token was
+// missing or too old, Changes holds the full state and the client should
+// replace its cache. SyncToken is sent with the next sync.
+type SyncResponse struct {
+	Results   []SyncResult `json:"results"`
+	Changes   SyncChanges  `json:"changes"`
+	Reset     bool         `json:"reset"`
+	SyncToken string       `json:"sync_token"`
+}
+
+// WorkoutSet is a single logged set of a workout on a given day.
+type WorkoutSet struct {
 	ID        string    `json:"id"`
 	UserID    string    `json:"user_id"`
 	WorkoutID string    `json:"workout_id"`
+	Date      string    `json:"date"`
+	SetNumber int       `json:"setNumber"`
+	Reps      *int      `json:"reps,omitempty"`
 	Weight    *float64  `json:"weight,omitempty"`
-	Time      *int      `json:"time,omitempty"`
-	Date      time.Time `json:"date"`
+	Duration  *int      `json:"duration,omitempty"`
+	RPE       *float64  `json:"rpe,omitempty"`
+	Warmup    bool      `json:"warmup"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// WorkoutSetInput is one set in a POST /workouts/{id}/sets body. SetNumber
+// defaults to the next number for the day.
+type WorkoutSetInput struct {
+	SetNumber *int     `json:"setNumber"`
+	Reps      *int     `json:"reps"`
+	Weight    *float64 `json:"weight"`
+	Duration  *int     `json:"duration"`
+	RPE       *float64 `json:"rpe"`
+	Warmup    bool     `json:"warmup"`
+}
+
+// WorkoutSetLog is every set logged for a workout on one day, plus the
+// derived summary that is also stored in user_progress.
+type WorkoutSetLog struct {
+	WorkoutID  string       `json:"workout_id"`
+	Date       string       `json:"date"`
+	Sets       []WorkoutSet `json:"sets"`
+	UserWeight *float64     `json:"userWeight,omitempty"`
+	UserTime   *int         `json:"userTime,omitempty"`
+	// Records set by the sets just logged
+	Records []PersonalRecord `json:"records,omitempty"`
+}
+
+// WorkoutSession is one training session from start to finish. Progress
+// logged while a session is open is attached to it.
+type WorkoutSession struct {
+	ID              string         `json:"id"`
+	UserID          string         `json:"user_id"`
+	RoutineID       *string        `json:"routine_id,omitempty"`
+	RoutineName     *string        `json:"routine_name,omitempty"`
+	StartedAt       time.Time      `json:"started_at"`
+	EndedAt         *time.Time     `json:"ended_at,omitempty"`
+	DurationSeconds *int           `json:"durationSeconds,omitempty"`
+	Notes           *string        `json:"notes,omitempty"`
+	ProgressCount   int            `json:"progressCount"`
+	Progress        []UserProgress `json:"progress,omitempty"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+}
+
 type DB struct {
 	*sql.DB
-}
\ No newline at end of file
+}
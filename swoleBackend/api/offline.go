@@ -0,0 +1,468 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxSyncMutations caps the size of one POST /api/sync batch
+	maxSyncMutations = 500
+	// syncOverlap widens each change feed to catch writes that started
+	// before the previous token was issued but committed after it
+	syncOverlap = 5 * time.Second
+)
+
+// syncRejection is a mutation that can never be applied as sent.
+type syncRejection string
+
+func (e syncRejection) Error() string { return string(e) }
+
+func rejectf(format string, args ...interface{}) error {
+	return syncRejection(fmt.Sprintf(format, args...))
+}
+
+func encodeSyncToken(t time.Time) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(t.UTC().Format(time.RFC3339Nano)))
+}
+
+func decodeSyncToken(token string) (time.Time, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return time.Time{}, errors.New("invalid sync_token")
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(raw))
+	if err != nil {
+		return time.Time{}, errors.New("invalid sync_token")
+	}
+	return t, nil
+}
+
+// syncEntity returns the server ID of the entity created by an earlier
+// mutation of the given type.
+func syncEntity(q queryer, userID, clientID string, mutationType SyncMutationType) (string, error) {
+	var entityID sql.NullString
+	err := q.QueryRow(`
+		SELECT entity_id FROM sync_mutations
+		WHERE user_id = $1 AND client_id = $2 AND mutation_type = $3`, userID, clientID, mutationType).
+		Scan(&entityID)
+	if err == sql.ErrNoRows || (err == nil && !entityID.Valid) {
+		return "", rejectf("no %s mutation with client_id %q", mutationType, clientID)
+	}
+	return entityID.String, err
+}
+
+// sessionAt returns the user's session that was running at t, if any.
+func sessionAt(q queryer, userID string, t time.Time) (*string, error) {
+	var sessionID string
+	err := q.QueryRow(`
+		SELECT id FROM workout_sessions
+		WHERE user_id = $1 AND started_at <= $2 AND (ended_at IS NULL OR ended_at >= $2)
+		ORDER BY started_at DESC LIMIT 1`, userID, t).Scan(&sessionID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &sessionID, err
+}
+
+// logSyncProgress applies a progress.log mutation like POST
+// /workouts/{id}/progress, with the day taken from occurred_at. A newer
+// server write to the same entry wins over the queued one.
+func (db *DB) logSyncProgress(tx *sql.Tx, userID string, m SyncMutation) (string, SyncStatus, []PersonalRecord, error) {
+	if m.WorkoutID == "" {
+		return "", "", nil, rejectf("workout_id is required")
+	}
+	if exists, err := db.workoutExists(m.WorkoutID); err != nil {
+		return "", "", nil, err
+	} else if !exists {
+		return "", "", nil, rejectf("workout not found")
+	}
+
+	loc, err := db.userLocation(userID, m.Timezone)
+	if err != nil {
+		return "", "", nil, rejectf("%v", err)
+	}
+	date, err := logDate(loc, m.Date, m.OccurredAt)
+	if err != nil {
+		return "", "", nil, rejectf("%v", err)
+	}
+
+	var sessionID *string
+	switch {
+	case m.SessionClientID != "":
+		id, err := syncEntity(tx, userID, m.SessionClientID, SyncStartSession)
+		if err != nil {
+			return "", "", nil, err
+		}
+		sessionID = &id
+	case m.SessionID != "":
+		sessionID, err = sessionForLog(tx, userID, m.SessionID, true)
+		if err == errUnknownSession {
+			return "", "", nil, rejectf("%v", err)
+		}
+	case m.Date != "":
+		// Backdated entries only join a session the client names
+	case m.OccurredAt != nil:
+		sessionID, err = sessionAt(tx, userID, *m.OccurredAt)
+	default:
+		sessionID, err = sessionForLog(tx, userID, "", false)
+	}
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	var progressID string
+	err = tx.QueryRow(`
+		INSERT INTO user_progress (user_id, workout_id, weight, time, session_id, date)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, workout_id, date)
+		DO UPDATE SET weight = $3, time = $4, session_id = COALESCE($5, user_progress.session_id),
+		              version = user_progress.version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE $7::timestamptz IS NULL OR user_progress.updated_at <= $7::timestamptz
+		RETURNING id`,
+		userID, m.WorkoutID, m.UserWeight, m.UserTime, sessionID, date, m.OccurredAt).Scan(&progressID)
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow(`SELECT id FROM user_progress WHERE user_id = $1 AND workout_id = $2 AND date = $3`,
+			userID, m.WorkoutID, date).Scan(&progressID)
+		return progressID, SyncSuperseded, nil, err
+	} else if err != nil {
+		return "", "", nil, err
+	}
+
+	records, err := detectRecords(tx, userID, m.WorkoutID, date)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return progressID, SyncApplied, records, nil
+}
+
+// deleteSyncProgress applies a progress.delete mutation. An entry changed
+// on the server after the delete happened on the device is kept.
+func (db *DB) deleteSyncProgress(tx *sql.Tx, userID string, m SyncMutation) (string, SyncStatus, error) {
+	progressID := m.ProgressID
+	if m.ProgressClientID != "" {
+		id, err := syncEntity(tx, userID, m.ProgressClientID, SyncLogProgress)
+		if err != nil {
+			return "", "", err
+		}
+		progressID = id
+	}
+	if progressID == "" {
+		return "", "", rejectf("progress_id or progress_client_id is required")
+	}
+
+	snapshot, err := ownProgress(tx, progressID, userID)
+	if err == errProgressNotFound || err == errProgressNotOwned {
+		return "", "", rejectf("%v", err)
+	} else if err != nil {
+		return "", "", err
+	}
+	if m.OccurredAt != nil && snapshot.Progress.UpdatedAt.After(*m.OccurredAt) {
+		return progressID, SyncSuperseded, nil
+	}
+	return progressID, SyncApplied, deleteProgress(tx, snapshot)
+}
+
+// startSyncSession applies a session.start mutation. Like POST /sessions,
+// it is rejected while another session is open.
+func (db *DB) startSyncSession(tx *sql.Tx, userID string, m SyncMutation) (string, error) {
+	if m.RoutineID != nil {
+		if _, err := db.loadRoutine(*m.RoutineID); err == sql.ErrNoRows {
+			return "", rejectf("routine not found")
+		} else if err != nil {
+			return "", err
+		}
+	}
+
+	var open bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM workout_sessions WHERE user_id = $1 AND ended_at IS NULL)`,
+		userID).Scan(&open)
+	if err != nil {
+		return "", err
+	}
+	if open {
+		return "", rejectf("another session is already open")
+	}
+
+	startedAt := m.StartedAt
+	if startedAt == nil {
+		startedAt = m.OccurredAt
+	}
+	var sessionID string
+	err = tx.QueryRow(`
+		INSERT INTO workout_sessions (user_id, routine_id, started_at, notes)
+		VALUES ($1, $2, COALESCE($3, CURRENT_TIMESTAMP), $4)
+		RETURNING id`, userID, m.RoutineID, startedAt, m.Notes).Scan(&sessionID)
+	if isUniqueViolation(err) {
+		return "", rejectf("another session is already open")
+	}
+	return sessionID, err
+}
+
+// finishSyncSession applies a session.finish mutation, ending the session
+// at ended_at or occurred_at. Finishing an open session moves the user's
+// rotation past its routine.
+func (db *DB) finishSyncSession(tx *sql.Tx, userID string, m SyncMutation) (string, error) {
+	sessionID := m.SessionID
+	if m.SessionClientID != "" {
+		id, err := syncEntity(tx, userID, m.SessionClientID, SyncStartSession)
+		if err != nil {
+			return "", err
+		}
+		sessionID = id
+	}
+	if sessionID == "" {
+		return "", rejectf("session_id or session_client_id is required")
+	}
+
+	var startedAt time.Time
+	var open bool
+	var routineID sql.NullString
+	err := tx.QueryRow(`
+		SELECT started_at, ended_at IS NULL, routine_id FROM workout_sessions
+		WHERE id::text = $1 AND user_id = $2 FOR UPDATE`,
+		sessionID, userID).Scan(&startedAt, &open, &routineID)
+	if err == sql.ErrNoRows {
+		return "", rejectf("%v", errUnknownSession)
+	} else if err != nil {
+		return "", err
+	}
+
+	endedAt := time.Now()
+	if m.EndedAt != nil {
+		endedAt = *m.EndedAt
+	} else if m.OccurredAt != nil {
+		endedAt = *m.OccurredAt
+	}
+	if endedAt.Before(startedAt) {
+		return "", rejectf("ended_at must not be before started_at")
+	}
+
+	_, err = tx.Exec(`
+		UPDATE workout_sessions
+		SET ended_at = $2, notes = COALESCE($3, notes), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, sessionID, endedAt, m.Notes)
+	if err == nil && open && routineID.Valid {
+		err = advanceRotation(tx, userID, routineID.String)
+	}
+	return sessionID, err
+}
+
+// applySyncMutation applies one mutation in its own transaction and
+// remembers its result, so a batch that is sent again reports the
+// original outcome instead of applying it twice.
+func (db *DB) applySyncMutation(userID string, m SyncMutation) SyncResult {
+	result := SyncResult{ClientID: m.ClientID}
+	if m.ClientID == "" || len(m.ClientID) > 100 {
+		result.Status, result.Error = SyncRejected, "client_id is required (at most 100 characters)"
+		return result
+	}
+
+	var stored []byte
+	err := db.QueryRow(`SELECT result FROM sync_mutations WHERE user_id = $1 AND client_id = $2`,
+		userID, m.ClientID).Scan(&stored)
+	if err == nil {
+		if err := json.Unmarshal(stored, &result); err == nil {
+			result.Status = SyncDuplicate
+			return result
+		}
+	} else if err != sql.ErrNoRows {
+		result.Status, result.Error = SyncFailed, err.Error()
+		return result
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		result.Status, result.Error = SyncFailed, err.Error()
+		return result
+	}
+	defer tx.Rollback()
+
+	result.Status = SyncApplied
+	switch m.Type {
+	case SyncLogProgress:
+		result.ID, result.Status, result.Records, err = db.logSyncProgress(tx, userID, m)
+	case SyncDeleteProgress:
+		result.ID, result.Status, err = db.deleteSyncProgress(tx, userID, m)
+	case SyncStartSession:
+		result.ID, err = db.startSyncSession(tx, userID, m)
+	case SyncFinishSession:
+		result.ID, err = db.finishSyncSession(tx, userID, m)
+	default:
+		err = rejectf("unknown mutation type %q", m.Type)
+	}
+
+	var rejection syncRejection
+	if errors.As(err, &rejection) {
+		result.Status, result.Error, result.Records = SyncRejected, rejection.Error(), nil
+		return result
+	} else if err != nil {
+		log.Printf("Error applying sync mutation %s: %v", m.ClientID, err)
+		result.Status, result.Error, result.Records = SyncFailed, err.Error(), nil
+		return result
+	}
+
+	data, err := json.Marshal(result)
+	if err == nil {
+		var entityID *string
+		if result.ID != "" {
+			entityID = &result.ID
+		}
+		_, err = tx.Exec(`
+			INSERT INTO sync_mutations (user_id, client_id, mutation_type, entity_id, result)
+			VALUES ($1, $2, $3, $4, $5)`, userID, m.ClientID, m.Type, entityID, data)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if isUniqueViolation(err) {
+		// The same mutation arrived concurrently and was applied there
+		result.Status, result.Records = SyncDuplicate, nil
+	} else if err != nil {
+		result.Status, result.Error, result.Records = SyncFailed, err.Error(), nil
+	}
+	return result
+}
+
+// syncChanges collects the user's progress and sessions changed after
+// since, or all of them when since is nil.
+func (db *DB) syncChanges(userID string, since *time.Time) (*SyncChanges, error) {
+	changes := &SyncChanges{Progress: []UserProgress{}, DeletedProgress: []string{}, Sessions: []WorkoutSession{}}
+
+	rows, err := db.Query(`
+		SELECT `+progressColumns+` FROM user_progress up
+		WHERE up.user_id = $1 AND ($2::timestamptz IS NULL OR up.updated_at > $2::timestamptz)
+		ORDER BY up.updated_at`, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p, err := scanUserProgress(rows)
+		if err != nil {
+			return nil, err
+		}
+		changes.Progress = append(changes.Progress, *p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(sessionQuery+`
+		WHERE s.user_id = $1 AND ($2::timestamptz IS NULL OR s.updated_at > $2::timestamptz)
+		ORDER BY s.updated_at`, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		changes.Sessions = append(changes.Sessions, *s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if since == nil {
+		return changes, nil
+	}
+	// Deletes are only known from the audit trail
+	rows, err = db.Query(`
+		SELECT DISTINCT pa.progress_id FROM progress_audit pa
+		WHERE pa.user_id = $1 AND pa.action = $2 AND pa.created_at > $3
+		  AND NOT EXISTS (SELECT 1 FROM user_progress up WHERE up.id = pa.progress_id)`,
+		userID, ProgressDeleted, *since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		changes.DeletedProgress = append(changes.DeletedProgress, id)
+	}
+	return changes, rows.Err()
+}
+
+// Sync applies a batch of mutations queued by an offline client, in order,
+// and returns a result per mutation plus the server changes since the
+// client's sync_token. A missing token, or one older than the progress
+// retention window (deletes may have been forgotten), resets the client
+// with the full state.
+func (db *DB) Sync(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		UserID    string         `json:"user_id"`
+		SyncToken string         `json:"sync_token"`
+		Mutations []SyncMutation `json:"mutations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body.Mutations) > maxSyncMutations {
+		http.Error(w, fmt.Sprintf("At most %d mutations per sync", maxSyncMutations), http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := db.requireUserID(w, body.UserID)
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var since *time.Time
+	if body.SyncToken != "" {
+		t, err := decodeSyncToken(body.SyncToken)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if time.Since(t) < progressRetention {
+			t = t.Add(-syncOverlap)
+			since = &t
+		}
+	}
+
+	response := SyncResponse{Results: []SyncResult{}, Reset: since == nil}
+	for _, m := range body.Mutations {
+		m.UserWeight = unit.pounds(m.UserWeight)
+		result := db.applySyncMutation(userID, m)
+		for i := range result.Records {
+			result.Records[i].inUnit(unit)
+		}
+		response.Results = append(response.Results, result)
+	}
+
+	// The token is taken from the database clock before reading changes, so
+	// anything written afterwards is in the next feed
+	var now time.Time
+	if err := db.QueryRow(`SELECT CURRENT_TIMESTAMP`).Scan(&now); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	changes, err := db.syncChanges(userID, since)
+	if err != nil {
+		log.Printf("Error loading sync changes: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response.Changes = *changes
+	response.Changes.inUnit(unit)
+	response.SyncToken = encodeSyncToken(now)
+
+	writeJSON(w, http.StatusOK, response)
+}
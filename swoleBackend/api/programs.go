@@ -0,0 +1,571 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Programs are multi-week blocks that users enroll in from a start date.
+// Weeks are counted Monday to Sunday from the week of the start date; while
+// an enrollment covers a week, the week schedule shows that program week
+// instead of the user's own schedule. Of overlapping enrollments the one
+// that started last wins.
+
+const maxProgramWeeks = 52
+
+// check validates a program and puts its day names in canonical form.
+// Omitted load percentages default to 100.
+func (in *ProgramInput) check(q queryer) error {
+	if in.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(in.Weeks) == 0 || len(in.Weeks) > maxProgramWeeks {
+		return fmt.Errorf("a program needs between 1 and %d weeks", maxProgramWeeks)
+	}
+	for i := range in.Weeks {
+		week := &in.Weeks[i]
+		week.Number = i + 1
+		if week.LoadPercent == 0 {
+			week.LoadPercent = 100
+		}
+		if week.LoadPercent < 0 {
+			return fmt.Errorf("week %d: load_percent must be positive", week.Number)
+		}
+
+		schedule := make(map[string][]string, len(week.Schedule))
+		for name, routineIDs := range week.Schedule {
+			day, err := parseWeekDay(name)
+			if err != nil {
+				return fmt.Errorf("week %d: %v", week.Number, err)
+			}
+			if _, dup := schedule[day]; dup {
+				return fmt.Errorf("week %d: %s is listed twice", week.Number, day)
+			}
+			if err := checkDayRoutines(q, day, routineIDs); err != nil {
+				return fmt.Errorf("week %d: %v", week.Number, err)
+			}
+			schedule[day] = routineIDs
+		}
+		week.Schedule = schedule
+	}
+	return nil
+}
+
+// insertProgramWeeks stores a checked program's weeks.
+func insertProgramWeeks(q queryer, programID string, weeks []ProgramWeek) error {
+	for _, week := range weeks {
+		var weekID string
+		err := q.QueryRow(`
+			INSERT INTO program_weeks (program_id, week_number, name, load_percent, rep_change)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id`, programID, week.Number, week.Name, week.LoadPercent, week.RepChange).Scan(&weekID)
+		if err != nil {
+			return err
+		}
+		for _, day := range weekDays {
+			for pos, routineID := range week.Schedule[day] {
+				_, err := q.Exec(`
+					INSERT INTO program_week_routines (week_id, day, routine_id, position)
+					VALUES ($1, $2, $3, $4)`, weekID, day, routineID, pos)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (db *DB) loadProgram(programID string) (*Program, error) {
+	var p Program
+	var description sql.NullString
+	err := db.QueryRow(`
+		SELECT id, name, description, version, created_at, updated_at
+		FROM programs WHERE id::text = $1`, programID).
+		Scan(&p.ID, &p.Name, &description, &p.Version, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if description.Valid {
+		p.Description = &description.String
+	}
+
+	rows, err := db.Query(`
+		SELECT pw.week_number, pw.name, pw.load_percent, pw.rep_change, pwr.day, pwr.routine_id
+		FROM program_weeks pw
+		LEFT JOIN program_week_routines pwr ON pwr.week_id = pw.id
+		    AND pwr.routine_id IN (SELECT id FROM routines WHERE retired_at IS NULL)
+		WHERE pw.program_id = $1
+		ORDER BY pw.week_number, pwr.position`, p.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	p.Weeks = []ProgramWeek{}
+	for rows.Next() {
+		var week ProgramWeek
+		var name, day, routineID sql.NullString
+		if err := rows.Scan(&week.Number, &name, &week.LoadPercent, &week.RepChange, &day, &routineID); err != nil {
+			return nil, err
+		}
+		if n := len(p.Weeks); n == 0 || p.Weeks[n-1].Number != week.Number {
+			if name.Valid {
+				week.Name = &name.String
+			}
+			week.Schedule = make(map[string][]string, len(weekDays))
+			for _, d := range weekDays {
+				week.Schedule[d] = []string{}
+			}
+			p.Weeks = append(p.Weeks, week)
+		}
+		if day.Valid && routineID.Valid {
+			schedule := p.Weeks[len(p.Weeks)-1].Schedule
+			schedule[day.String] = append(schedule[day.String], routineID.String)
+		}
+	}
+	return &p, rows.Err()
+}
+
+// lockProgram locks a program for a change, writing a 404 if it doesn't
+// exist or a 409 with the current program if If-Match is stale.
+func (db *DB) lockProgram(w http.ResponseWriter, r *http.Request, q queryer, programID string) (string, bool) {
+	id, version, err := lockVersion(q, "programs", programID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Program not found", http.StatusNotFound)
+		return "", false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	if !versionMatches(r, version) {
+		current, err := db.loadProgram(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return "", false
+		}
+		writeVersionConflict(w, version, current)
+		return "", false
+	}
+	return id, true
+}
+
+// writeProgram responds with a program and its ETag.
+func (db *DB) writeProgram(w http.ResponseWriter, status int, programID string) {
+	program, err := db.loadProgram(programID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setETag(w, program.Version)
+	writeJSON(w, status, program)
+}
+
+func (db *DB) GetPrograms(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.Query(`SELECT id FROM programs ORDER BY name, created_at`)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	programs := []Program{}
+	for _, id := range ids {
+		program, err := db.loadProgram(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		programs = append(programs, *program)
+	}
+	writeJSON(w, http.StatusOK, programs)
+}
+
+func (db *DB) GetProgram(w http.ResponseWriter, r *http.Request) {
+	program, err := db.loadProgram(mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		http.Error(w, "Program not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setETag(w, program.Version)
+	writeJSON(w, http.StatusOK, program)
+}
+
+func (db *DB) CreateProgram(w http.ResponseWriter, r *http.Request) {
+	var in ProgramInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := in.check(db); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var programID string
+	err = tx.QueryRow(`
+		INSERT INTO programs (name, description)
+		VALUES ($1, $2)
+		RETURNING id`, in.Name, in.Description).Scan(&programID)
+	if err == nil {
+		err = insertProgramWeeks(tx, programID, in.Weeks)
+	}
+	if err != nil {
+		log.Printf("Error creating program: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	db.writeProgram(w, http.StatusCreated, programID)
+}
+
+// UpdateProgram replaces a program's name, description and weeks. Users
+// already enrolled follow the new weeks from their original start date.
+func (db *DB) UpdateProgram(w http.ResponseWriter, r *http.Request) {
+	var in ProgramInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := in.check(db); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	programID, ok := db.lockProgram(w, r, tx, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	_, err = tx.Exec(`
+		UPDATE programs SET name = $2, description = $3, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, programID, in.Name, in.Description)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM program_weeks WHERE program_id = $1`, programID)
+	}
+	if err == nil {
+		err = insertProgramWeeks(tx, programID, in.Weeks)
+	}
+	if err != nil {
+		log.Printf("Error updating program: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	db.writeProgram(w, http.StatusOK, programID)
+}
+
+// DeleteProgram deletes a program and every enrollment in it.
+func (db *DB) DeleteProgram(w http.ResponseWriter, r *http.Request) {
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	programID, ok := db.lockProgram(w, r, tx, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM programs WHERE id = $1`, programID); err != nil {
+		log.Printf("Error deleting program: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const enrollmentQuery = `
+	SELECT e.id, e.user_id, e.program_id, p.name, e.start_date::text, e.created_at,
+	       (SELECT COUNT(*) FROM program_weeks pw WHERE pw.program_id = p.id)
+	FROM program_enrollments e
+	JOIN programs p ON p.id = e.program_id`
+
+func scanEnrollment(row interface{ Scan(...interface{}) error }) (*ProgramEnrollment, error) {
+	var e ProgramEnrollment
+	err := row.Scan(&e.ID, &e.UserID, &e.ProgramID, &e.ProgramName, &e.StartDate, &e.CreatedAt, &e.Weeks)
+	if err != nil {
+		return nil, err
+	}
+	start, err := time.Parse(dateLayout, e.StartDate)
+	if err != nil {
+		return nil, err
+	}
+	e.EndDate = weekStart(start).AddDate(0, 0, 7*e.Weeks-1).Format(dateLayout)
+	return &e, nil
+}
+
+// EnrollInProgram starts a user on a program from the week of start_date
+// (default today in their timezone).
+func (db *DB) EnrollInProgram(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	var in struct {
+		ProgramID string `json:"program_id"`
+		StartDate string `json:"start_date"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if in.ProgramID == "" {
+		http.Error(w, "program_id is required", http.StatusBadRequest)
+		return
+	}
+	if in.StartDate == "" {
+		loc, err := db.userLocation(userID, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		in.StartDate = time.Now().In(loc).Format(dateLayout)
+	} else if _, err := time.Parse(dateLayout, in.StartDate); err != nil {
+		http.Error(w, fmt.Sprintf("invalid start_date %q, expected YYYY-MM-DD", in.StartDate), http.StatusBadRequest)
+		return
+	}
+
+	var programID string
+	err := db.QueryRow(`SELECT id FROM programs WHERE id::text = $1`, in.ProgramID).Scan(&programID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Program not found", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var id string
+	err = db.QueryRow(`
+		INSERT INTO program_enrollments (user_id, program_id, start_date)
+		VALUES ($1, $2, $3)
+		RETURNING id`, userID, programID, in.StartDate).Scan(&id)
+	if err != nil {
+		log.Printf("Error enrolling in program: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	enrollment, err := scanEnrollment(db.QueryRow(enrollmentQuery+` WHERE e.id = $1`, id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, enrollment)
+}
+
+// GetProgramEnrollments lists a user's enrollments, latest start first.
+func (db *DB) GetProgramEnrollments(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	rows, err := db.Query(enrollmentQuery+` WHERE e.user_id = $1 ORDER BY e.start_date DESC, e.created_at DESC`, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	enrollments := []ProgramEnrollment{}
+	for rows.Next() {
+		e, err := scanEnrollment(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		enrollments = append(enrollments, *e)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, enrollments)
+}
+
+// DeleteProgramEnrollment takes a user off a program; their own week
+// schedule applies again.
+func (db *DB) DeleteProgramEnrollment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+
+	e, err := scanEnrollment(db.QueryRow(enrollmentQuery+` WHERE e.id::text = $1`, mux.Vars(r)["id"]))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Enrollment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if e.UserID != userID {
+		http.Error(w, "Enrollment belongs to another user", http.StatusForbidden)
+		return
+	}
+	if _, err := db.Exec(`DELETE FROM program_enrollments WHERE id = $1`, e.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// load scales a prescribed weight by the week's load, rounded down to a
+// plate in the unit the user sees.
+func (p *ScheduledProgram) load(weight *float64, unit WeightUnit) *float64 {
+	if weight == nil || p.LoadPercent == 100 {
+		return weight
+	}
+	w := roundToPlate(unit.fromPounds(*weight) * p.LoadPercent / 100)
+	return unit.pounds(&w)
+}
+
+// reps moves prescribed reps by the week's rep change, keeping at least one.
+func (p *ScheduledProgram) reps(reps *int) *int {
+	if reps == nil || p.RepChange == 0 {
+		return reps
+	}
+	n := *reps + p.RepChange
+	if n < 1 {
+		n = 1
+	}
+	return &n
+}
+
+// apply adjusts a workout's prescription and suggestion for the week.
+func (p *ScheduledProgram) apply(w *Workout, unit WeightUnit) {
+	w.Weight = p.load(w.Weight, unit)
+	w.SuggestedWeight = p.load(w.SuggestedWeight, unit)
+	w.Reps = p.reps(w.Reps)
+	w.SuggestedReps = p.reps(w.SuggestedReps)
+}
+
+// enrolledProgram returns the name of the program a user follows in the
+// week starting on start, or sql.ErrNoRows if no enrollment covers it.
+func enrolledProgram(q queryer, userID, start string) (string, error) {
+	var name string
+	err := q.QueryRow(`
+		SELECT p.name
+		FROM program_enrollments e
+		JOIN programs p ON p.id = e.program_id
+		JOIN program_weeks pw ON pw.program_id = p.id
+		WHERE e.user_id = $1
+		  AND pw.week_number = ($2::date - date_trunc('week', e.start_date)::date) / 7 + 1
+		ORDER BY e.start_date DESC, e.created_at DESC
+		LIMIT 1`, userID, start).Scan(&name)
+	return name, err
+}
+
+// programWeek returns the program week a user is enrolled in for the week
+// starting on start, or sql.ErrNoRows if no enrollment covers it.
+// identifier is the user's email or UUID, date the day whose progress is
+// filled in and unit the one weights will be shown in.
+func (db *DB) programWeek(userID, identifier, start, date string, unit WeightUnit) (*WeekSchedule, error) {
+	var program ScheduledProgram
+	var weekID string
+	var weekName sql.NullString
+	err := db.QueryRow(`
+		SELECT e.id, p.id, p.name, pw.id, pw.week_number, pw.name, pw.load_percent, pw.rep_change,
+		       (SELECT COUNT(*) FROM program_weeks WHERE program_id = p.id)
+		FROM program_enrollments e
+		JOIN programs p ON p.id = e.program_id
+		JOIN program_weeks pw ON pw.program_id = p.id
+		WHERE e.user_id = $1
+		  AND pw.week_number = ($2::date - date_trunc('week', e.start_date)::date) / 7 + 1
+		ORDER BY e.start_date DESC, e.created_at DESC
+		LIMIT 1`, userID, start).
+		Scan(&program.EnrollmentID, &program.ID, &program.Name, &weekID, &program.Week, &weekName,
+			&program.LoadPercent, &program.RepChange, &program.Weeks)
+	if err != nil {
+		return nil, err
+	}
+	if weekName.Valid {
+		program.WeekName = &weekName.String
+	}
+
+	week := emptyWeek(start)
+	week.UserID = userID
+	week.Program = &program
+	dayIndex := make(map[string]int, len(weekDays))
+	for i, day := range weekDays {
+		dayIndex[day] = i
+	}
+
+	rows, err := db.Query(`
+		SELECT pwr.day, r.id, r.name, r.description, r.category, r.difficulty, r.duration_minutes, r.version
+		FROM program_week_routines pwr
+		JOIN routines r ON r.id = pwr.routine_id AND r.retired_at IS NULL
+		WHERE pwr.week_id = $1
+		ORDER BY pwr.position`, weekID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day, rID, rName string
+		var rDesc sql.NullString
+		var rVersion sql.NullInt64
+		var meta routineMeta
+		err := rows.Scan(&day, &rID, &rName, &rDesc, &meta.category, &meta.difficulty, &meta.duration, &rVersion)
+		if err != nil {
+			return nil, err
+		}
+		i, exists := dayIndex[day]
+		if !exists {
+			continue
+		}
+		routine := db.plannedRoutine(rID, rName, rDesc, meta, rVersion, identifier, date)
+		for j := range routine.Workouts {
+			program.apply(&routine.Workouts[j], unit)
+		}
+		week.Schedule[i].Routines = append(week.Schedule[i].Routines, routine)
+	}
+	return week, rows.Err()
+}
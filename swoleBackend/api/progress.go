@@ -0,0 +1,635 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// progressColumns selects the user_progress columns read by scanUserProgress.
+const progressColumns = `up.id, up.user_id, up.workout_id, up.session_id, up.weight, up.time,
+	up.date::text, up.version, up.created_at, up.updated_at`
+
+func scanUserProgress(row interface{ Scan(...interface{}) error }) (*UserProgress, error) {
+	var p UserProgress
+	var sessionID sql.NullString
+	var weight sql.NullFloat64
+	var t sql.NullInt64
+
+	err := row.Scan(&p.ID, &p.UserID, &p.WorkoutID, &sessionID, &weight, &t, &p.Date, &p.Version, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if sessionID.Valid {
+		p.SessionID = &sessionID.String
+	}
+	if weight.Valid {
+		p.Weight = &weight.Float64
+	}
+	if t.Valid {
+		v := int(t.Int64)
+		p.Time = &v
+	}
+	return &p, nil
+}
+
+// Progress pages are ordered newest first by (date, id); the cursor is the
+// position of the last entry returned.
+func encodeProgressCursor(p UserProgress) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(p.Date + "|" + p.ID))
+}
+
+func decodeProgressCursor(cursor string) (date, id string, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", errors.New("invalid cursor")
+	}
+	date, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return "", "", errors.New("invalid cursor")
+	}
+	if _, err := time.Parse(dateLayout, date); err != nil {
+		return "", "", errors.New("invalid cursor")
+	}
+	return date, id, nil
+}
+
+// listParam collects a repeatable query parameter, also splitting
+// comma-separated values (?workout_id=a&workout_id=b or ?workout_id=a,b).
+func listParam(r *http.Request, name string) []string {
+	var values []string
+	for _, v := range r.URL.Query()[name] {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+	}
+	return values
+}
+
+// progressFilters builds the WHERE clause for GET /api/progress. Entries are
+// always scoped to userID; from/to (inclusive), workout_id, exercise_id (id
+// or slug) and cursor narrow them further.
+func progressFilters(r *http.Request, userID string) (string, []interface{}, error) {
+	q := r.URL.Query()
+	args := []interface{}{userID}
+	conditions := []string{"up.user_id = $1"}
+
+	for _, bound := range []struct{ param, op string }{{"from", ">="}, {"to", "<="}} {
+		value := q.Get(bound.param)
+		if value == "" {
+			continue
+		}
+		if _, err := time.Parse(dateLayout, value); err != nil {
+			return "", nil, fmt.Errorf("invalid %s %q, expected YYYY-MM-DD", bound.param, value)
+		}
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf("up.date %s $%d::date", bound.op, len(args)))
+	}
+	if workoutIDs := listParam(r, "workout_id"); len(workoutIDs) > 0 {
+		args = append(args, pq.Array(workoutIDs))
+		conditions = append(conditions, fmt.Sprintf("up.workout_id::text = ANY($%d)", len(args)))
+	}
+	if exerciseIDs := listParam(r, "exercise_id"); len(exerciseIDs) > 0 {
+		args = append(args, pq.Array(exerciseIDs))
+		conditions = append(conditions, fmt.Sprintf(`w.exercise_id IN (
+			SELECT id FROM exercises WHERE id::text = ANY($%d) OR slug = ANY($%d))`, len(args), len(args)))
+	}
+	if cursor := q.Get("cursor"); cursor != "" {
+		date, id, err := decodeProgressCursor(cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, date, id)
+		conditions = append(conditions, fmt.Sprintf("(up.date, up.id::text) < ($%d::date, $%d)", len(args)-1, len(args)))
+	}
+
+	return "WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+// GetUserProgress returns a user's logged progress, newest first. Filters
+// combine with AND; repeated workout_id or exercise_id values match any of
+// them. limit defaults to 50 (max 200) and next_cursor fetches the next page.
+func (db *DB) GetUserProgress(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 200 {
+			http.Error(w, "limit must be between 1 and 200", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	where, args, err := progressFilters(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	args = append(args, limit+1)
+	rows, err := db.Query(`
+		SELECT `+progressColumns+`
+		FROM user_progress up
+		JOIN workouts w ON w.id = up.workout_id
+		`+where+`
+		ORDER BY up.date DESC, up.id::text DESC
+		LIMIT $`+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	page := ProgressPage{Progress: []UserProgress{}}
+	for rows.Next() {
+		p, err := scanUserProgress(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page.Progress = append(page.Progress, *p)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(page.Progress) > limit {
+		page.Progress = page.Progress[:limit]
+		cursor := encodeProgressCursor(page.Progress[limit-1])
+		page.NextCursor = &cursor
+	}
+	for i := range page.Progress {
+		page.Progress[i].inUnit(unit)
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// progressRetention is how long deleted progress can be restored; older
+// audit entries are purged.
+const progressRetention = 30 * 24 * time.Hour
+
+// sameValue reports whether two optional values are equal.
+func sameValue[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// loadProgressSnapshot locks a progress entry and loads it with the day's
+// sets.
+func loadProgressSnapshot(q queryer, progressID string) (*ProgressSnapshot, error) {
+	p, err := scanUserProgress(q.QueryRow(`
+		SELECT `+progressColumns+` FROM user_progress up
+		WHERE up.id::text = $1
+		FOR UPDATE`, progressID))
+	if err != nil {
+		return nil, err
+	}
+	setLog, err := loadSetLog(q, p.UserID, p.WorkoutID, p.Date)
+	if err != nil {
+		return nil, err
+	}
+	return &ProgressSnapshot{Progress: *p, Sets: setLog.Sets}, nil
+}
+
+var (
+	errProgressNotFound = errors.New("progress entry not found")
+	errProgressNotOwned = errors.New("progress entry belongs to another user")
+)
+
+// ownProgress loads a progress entry for a change by userID.
+func ownProgress(q queryer, progressID, userID string) (*ProgressSnapshot, error) {
+	snapshot, err := loadProgressSnapshot(q, progressID)
+	if err == sql.ErrNoRows {
+		return nil, errProgressNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if snapshot.Progress.UserID != userID {
+		return nil, errProgressNotOwned
+	}
+	return snapshot, nil
+}
+
+// requireOwnProgress loads a progress entry for a change, writing a 404 if
+// it doesn't exist or a 403 if it belongs to another user.
+func requireOwnProgress(w http.ResponseWriter, q queryer, progressID, userID string) (*ProgressSnapshot, bool) {
+	snapshot, err := ownProgress(q, progressID, userID)
+	switch err {
+	case nil:
+		return snapshot, true
+	case errProgressNotFound:
+		http.Error(w, "Progress entry not found", http.StatusNotFound)
+	case errProgressNotOwned:
+		http.Error(w, "Progress entry belongs to another user", http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return nil, false
+}
+
+// deleteProgress removes a progress entry and the day's sets, keeping a
+// snapshot in the audit trail.
+func deleteProgress(q queryer, snapshot *ProgressSnapshot) error {
+	p := snapshot.Progress
+	if _, err := q.Exec(`DELETE FROM workout_sets WHERE user_id = $1 AND workout_id = $2 AND date = $3`,
+		p.UserID, p.WorkoutID, p.Date); err != nil {
+		return err
+	}
+	if _, err := q.Exec(`DELETE FROM user_progress WHERE id = $1`, p.ID); err != nil {
+		return err
+	}
+	if err := auditProgress(q, ProgressDeleted, snapshot); err != nil {
+		return err
+	}
+	_, err := detectRecords(q, p.UserID, p.WorkoutID, p.Date)
+	return err
+}
+
+// auditProgress records a change to a progress entry and purges the user's
+// audit entries that are past the retention window.
+func auditProgress(q queryer, action ProgressAction, snapshot *ProgressSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	p := snapshot.Progress
+	_, err = q.Exec(`
+		INSERT INTO progress_audit (progress_id, user_id, action, snapshot)
+		VALUES ($1, $2, $3, $4)`, p.ID, p.UserID, action, data)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(`DELETE FROM progress_audit WHERE user_id = $1 AND created_at < $2`,
+		p.UserID, time.Now().Add(-progressRetention))
+	return err
+}
+
+// UpdateProgress edits a logged progress entry: userWeight, userTime, date
+// and session_id, each kept when left out. Moving an entry to another date
+// moves the day's sets with it. Entries derived from logged sets only take
+// a new weight or time through the sets.
+func (db *DB) UpdateProgress(w http.ResponseWriter, r *http.Request) {
+	progressID := mux.Vars(r)["id"]
+	userID, ok := db.requireUserID(w, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	before, ok := requireOwnProgress(w, tx, progressID, userID)
+	if !ok {
+		return
+	}
+	current := before.Progress
+	if !versionMatches(r, current.Version) {
+		shown := current
+		shown.inUnit(unit)
+		writeVersionConflict(w, current.Version, shown)
+		return
+	}
+
+	body := struct {
+		SessionID  string   `json:"session_id"`
+		UserWeight *float64 `json:"userWeight"`
+		UserTime   *int     `json:"userTime"`
+		Date       string   `json:"date"`
+		Timezone   string   `json:"timezone"`
+	}{UserWeight: unit.weight(current.Weight), UserTime: current.Time, Date: current.Date}
+	if current.SessionID != nil {
+		body.SessionID = *current.SessionID
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body.UserWeight = unit.edited(body.UserWeight, current.Weight)
+
+	if len(before.Sets) > 0 && (!sameValue(body.UserWeight, current.Weight) || !sameValue(body.UserTime, current.Time)) {
+		http.Error(w, "Progress is derived from logged sets; edit the sets instead", http.StatusConflict)
+		return
+	}
+	date, ok := db.requireLogDate(w, userID, body.Timezone, body.Date, nil)
+	if !ok {
+		return
+	}
+	sessionID := current.SessionID
+	if current.SessionID == nil || body.SessionID != *current.SessionID {
+		sessionID, err = sessionForLog(tx, userID, body.SessionID, true)
+		if err == errUnknownSession {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE user_progress SET weight = $2, time = $3, date = $4, session_id = $5,
+		                         version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, current.ID, body.UserWeight, body.UserTime, date, sessionID)
+	if isUniqueViolation(err) {
+		http.Error(w, "Progress is already logged for this workout on "+date, http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Printf("Error updating progress: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if date != current.Date {
+		_, err = tx.Exec(`
+			UPDATE workout_sets SET date = $4
+			WHERE user_id = $1 AND workout_id = $2 AND date = $3`, userID, current.WorkoutID, current.Date, date)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := auditProgress(tx, ProgressUpdated, before); err != nil {
+		log.Printf("Error auditing progress: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, day := range []string{current.Date, date} {
+		if _, err := detectRecords(tx, userID, current.WorkoutID, day); err != nil {
+			log.Printf("Error detecting records: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	updated, err := scanUserProgress(tx.QueryRow(`
+		SELECT `+progressColumns+` FROM user_progress up WHERE up.id = $1`, current.ID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	updated.inUnit(unit)
+	setETag(w, updated.Version)
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// DeleteProgress removes a progress entry and the sets logged for it that
+// day. The entry can be brought back with RestoreProgress until the
+// retention window passes.
+func (db *DB) DeleteProgress(w http.ResponseWriter, r *http.Request) {
+	progressID := mux.Vars(r)["id"]
+	userID, ok := db.requireUserID(w, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	before, ok := requireOwnProgress(w, tx, progressID, userID)
+	if !ok {
+		return
+	}
+	if !versionMatches(r, before.Progress.Version) {
+		shown := before.Progress
+		shown.inUnit(unit)
+		writeVersionConflict(w, before.Progress.Version, shown)
+		return
+	}
+	if err := deleteProgress(tx, before); err != nil {
+		log.Printf("Error deleting progress: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreProgress brings back the most recent delete of a progress entry,
+// with its original id and sets, if it is still within the retention
+// window.
+func (db *DB) RestoreProgress(w http.ResponseWriter, r *http.Request) {
+	progressID := mux.Vars(r)["id"]
+	userID, ok := db.requireUserID(w, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var auditID string
+	var data []byte
+	var deletedAt time.Time
+	err = tx.QueryRow(`
+		SELECT id, snapshot, created_at FROM progress_audit
+		WHERE progress_id::text = $1 AND user_id = $2 AND action = $3 AND restored_at IS NULL
+		ORDER BY created_at DESC
+		LIMIT 1
+		FOR UPDATE`, progressID, userID, ProgressDeleted).Scan(&auditID, &data, &deletedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "No deleted progress entry to restore", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if time.Since(deletedAt) > progressRetention {
+		http.Error(w, "Progress entry was deleted too long ago to restore", http.StatusGone)
+		return
+	}
+
+	var snapshot ProgressSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p := snapshot.Progress
+
+	var workoutExists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM workouts WHERE id = $1)`, p.WorkoutID).
+		Scan(&workoutExists); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !workoutExists {
+		http.Error(w, "The workout for this entry no longer exists", http.StatusConflict)
+		return
+	}
+
+	// The session may have been removed since; the entry then stands alone.
+	// The version moves on so ETags from before the delete stay stale.
+	_, err = tx.Exec(`
+		INSERT INTO user_progress (id, user_id, workout_id, weight, time, session_id, date, version, created_at)
+		VALUES ($1, $2, $3, $4, $5, (SELECT id FROM workout_sessions WHERE id::text = $6), $7, $8, $9)`,
+		p.ID, p.UserID, p.WorkoutID, p.Weight, p.Time, p.SessionID, p.Date, p.Version+1, p.CreatedAt)
+	if isUniqueViolation(err) {
+		http.Error(w, "Progress is already logged for this workout on "+p.Date, http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Printf("Error restoring progress: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, set := range snapshot.Sets {
+		_, err := tx.Exec(`
+			INSERT INTO workout_sets (id, user_id, workout_id, date, set_number, reps, weight, duration, rpe,
+			                          is_warmup, session_id, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10,
+			        (SELECT id FROM workout_sessions WHERE id::text = $11), $12)`,
+			set.ID, set.UserID, set.WorkoutID, set.Date, set.SetNumber, set.Reps, set.Weight, set.Duration,
+			set.RPE, set.Warmup, p.SessionID, set.CreatedAt)
+		if isUniqueViolation(err) {
+			http.Error(w, "Sets are already logged for this workout on "+p.Date, http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE progress_audit SET restored_at = CURRENT_TIMESTAMP WHERE id = $1`, auditID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := auditProgress(tx, ProgressRestored, &snapshot); err != nil {
+		log.Printf("Error auditing progress: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := detectRecords(tx, userID, p.WorkoutID, p.Date); err != nil {
+		log.Printf("Error detecting records: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	restored, err := scanUserProgress(tx.QueryRow(`
+		SELECT `+progressColumns+` FROM user_progress up WHERE up.id = $1`, p.ID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	restored.inUnit(unit)
+	setETag(w, restored.Version)
+	writeJSON(w, http.StatusOK, restored)
+}
+
+// GetProgressAudit lists a user's changes to progress entries within the
+// retention window, newest first. Optional filters: action (update, delete
+// or restore) and progress_id.
+func (db *DB) GetProgressAudit(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	userID, ok := db.requireUserID(w, params.Get("user_id"))
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+
+	action := ProgressAction(params.Get("action"))
+	switch action {
+	case "", ProgressUpdated, ProgressDeleted, ProgressRestored:
+	default:
+		http.Error(w, "action must be one of update, delete, restore", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, progress_id, user_id, action, snapshot, restored_at, created_at
+		FROM progress_audit
+		WHERE user_id = $1 AND created_at >= $2
+		  AND ($3 = '' OR action = $3)
+		  AND ($4 = '' OR progress_id::text = $4)
+		ORDER BY created_at DESC`,
+		userID, time.Now().Add(-progressRetention), action, params.Get("progress_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	entries := []ProgressAudit{}
+	for rows.Next() {
+		var entry ProgressAudit
+		var data []byte
+		var restoredAt sql.NullTime
+		err := rows.Scan(&entry.ID, &entry.ProgressID, &entry.UserID, &entry.Action, &data, &restoredAt,
+			&entry.CreatedAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := json.Unmarshal(data, &entry.Snapshot); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if restoredAt.Valid {
+			entry.RestoredAt = &restoredAt.Time
+		}
+		entry.ExpiresAt = entry.CreatedAt.Add(progressRetention)
+		entry.Snapshot.inUnit(unit)
+		entries = append(entries, entry)
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
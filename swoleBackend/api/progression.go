@@ -0,0 +1,243 @@
+package api
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Value stores a rule in the workouts.progression JSONB column.
+func (r *ProgressionRule) Value() (driver.Value, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return json.Marshal(r)
+}
+
+func parseProgression(raw []byte) (*ProgressionRule, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	var rule ProgressionRule
+	if err := json.Unmarshal(raw, &rule); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (r *ProgressionRule) validate(in *WorkoutInput) error {
+	positive := func(field string, v *int) error {
+		if v != nil && *v < 1 {
+			return fmt.Errorf("progression %s must be at least 1", field)
+		}
+		return nil
+	}
+	for field, v := range map[string]*int{"repIncrement": r.RepIncrement, "minReps": r.MinReps,
+		"maxReps": r.MaxReps, "timeIncrement": r.TimeIncrement} {
+		if err := positive(field, v); err != nil {
+			return err
+		}
+	}
+	if r.WeightIncrement != nil && *r.WeightIncrement <= 0 {
+		return errors.New("progression weightIncrement must be positive")
+	}
+
+	switch r.Type {
+	case LinearProgression:
+		if r.WeightIncrement == nil {
+			return errors.New("linear progression needs weightIncrement")
+		}
+	case DoubleProgression:
+		if r.WeightIncrement == nil || r.MaxReps == nil {
+			return errors.New("double progression needs weightIncrement and maxReps")
+		}
+		minReps := r.MinReps
+		if minReps == nil {
+			minReps = in.Reps
+		}
+		if minReps == nil {
+			return errors.New("double progression needs minReps or the workout's reps")
+		}
+		if *minReps > *r.MaxReps {
+			return errors.New("progression minReps must not exceed maxReps")
+		}
+	case TimeProgression:
+		if r.TimeIncrement == nil {
+			return errors.New("time progression needs timeIncrement")
+		}
+	default:
+		return fmt.Errorf("invalid progression type %q", r.Type)
+	}
+	return nil
+}
+
+// lastSession summarises a workout's most recent logged session. Weight is
+// the heaviest working weight; Sets and MinReps cover the sets done at it.
+type lastSession struct {
+	weight   *float64
+	sets     int
+	minReps  *int
+	duration *int
+}
+
+// loadLastSessions returns the last session before date for each workout
+// of a routine that the user has logged.
+func (db *DB) loadLastSessions(userID, routineID, date string) (map[string]*lastSession, error) {
+	rows, err := db.Query(`
+		SELECT workout_id, weight, reps, duration, sets FROM (
+			SELECT p.*, RANK() OVER (PARTITION BY p.workout_id ORDER BY p.date DESC) AS recency
+			FROM `+loggedPerformances+`
+			WHERE p.user_id = $1 AND p.date < $3::date
+			  AND p.workout_id IN (SELECT id FROM workouts WHERE routine_id = $2)
+		) recent
+		WHERE recency = 1
+		ORDER BY workout_id, weight DESC NULLS LAST`, userID, routineID, date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := map[string]*lastSession{}
+	for rows.Next() {
+		var workoutID string
+		var weight sql.NullFloat64
+		var reps, duration sql.NullInt64
+		var sets int
+		if err := rows.Scan(&workoutID, &weight, &reps, &duration, &sets); err != nil {
+			return nil, err
+		}
+
+		s := sessions[workoutID]
+		if s == nil {
+			// Rows come heaviest first, so the first one sets the working weight
+			s = &lastSession{}
+			if weight.Valid {
+				s.weight = &weight.Float64
+			}
+			sessions[workoutID] = s
+		}
+		if duration.Valid && (s.duration == nil || int(duration.Int64) > *s.duration) {
+			d := int(duration.Int64)
+			s.duration = &d
+		}
+		if s.weight != nil && (!weight.Valid || weight.Float64 != *s.weight) {
+			continue
+		}
+		s.sets += sets
+		if reps.Valid && (s.minReps == nil || int(reps.Int64) < *s.minReps) {
+			r := int(reps.Int64)
+			s.minReps = &r
+		}
+	}
+	return sessions, rows.Err()
+}
+
+func addWeight(weight *float64, increment float64) *float64 {
+	if weight == nil {
+		return nil
+	}
+	w := *weight + increment
+	return &w
+}
+
+// suggest fills in the next-session prescription for w from its rule and
+// the last session.
+func (r *ProgressionRule) suggest(w *Workout, last *lastSession) {
+	prescribedSets := 1
+	if w.Sets != nil && *w.Sets > 0 {
+		prescribedSets = *w.Sets
+	}
+	allSets := last.sets >= prescribedSets
+
+	weight := last.weight
+	if weight == nil {
+		weight = w.Weight
+	}
+
+	switch r.Type {
+	case LinearProgression:
+		hit := allSets && (w.Reps == nil || last.minReps != nil && *last.minReps >= *w.Reps)
+		w.SuggestedWeight, w.SuggestedReps = weight, w.Reps
+		if hit {
+			w.SuggestedWeight = addWeight(weight, *r.WeightIncrement)
+		}
+
+	case DoubleProgression:
+		minReps := w.Reps
+		if r.MinReps != nil {
+			minReps = r.MinReps
+		}
+		if minReps == nil {
+			return
+		}
+		reps := *minReps
+		if last.minReps != nil && *last.minReps > reps {
+			reps = *last.minReps
+		}
+		increment := 1
+		if r.RepIncrement != nil {
+			increment = *r.RepIncrement
+		}
+
+		switch {
+		case allSets && reps >= *r.MaxReps:
+			w.SuggestedWeight = addWeight(weight, *r.WeightIncrement)
+			reps = *minReps
+		case allSets:
+			w.SuggestedWeight = weight
+			reps = min(reps+increment, *r.MaxReps)
+		default:
+			w.SuggestedWeight = weight
+		}
+		w.SuggestedReps = &reps
+
+	case TimeProgression:
+		if last.duration == nil {
+			return
+		}
+		t := *last.duration
+		if w.Time == nil || t >= *w.Time {
+			t += *r.TimeIncrement
+		} else {
+			t = *w.Time
+		}
+		w.SuggestedTime = &t
+	}
+}
+
+// applyProgression sets the suggested weight, reps and time of every
+// workout with a progression rule from the user's last session before
+// date. Workouts the user has never logged keep their static prescription.
+func (db *DB) applyProgression(routineID, userID, date string, workouts []Workout) error {
+	hasRules := false
+	for _, w := range workouts {
+		hasRules = hasRules || w.Progression != nil
+	}
+	if !hasRules || userID == "" {
+		return nil
+	}
+
+	sessions, err := db.loadLastSessions(userID, routineID, date)
+	if err != nil {
+		return err
+	}
+	for i := range workouts {
+		w := &workouts[i]
+		if last := sessions[w.ID]; w.Progression != nil && last != nil {
+			w.Progression.suggest(w, last)
+		}
+	}
+	return nil
+}
+
+// progressionJSON renders a rule for sync diffs.
+func progressionJSON(r *ProgressionRule) *string {
+	if r == nil {
+		return nil
+	}
+	raw, _ := json.Marshal(r)
+	s := string(raw)
+	return &s
+}
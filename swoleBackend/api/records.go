@@ -0,0 +1,334 @@
+package api
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// performance is one logged effort for an exercise: a working set, or a
+// user_progress summary (with the workout's prescribed reps) for days
+// logged without sets.
+type performance struct {
+	weight   *float64
+	reps     *int
+	duration *int
+}
+
+// loggedPerformances is every working set a user logged, plus the
+// user_progress summary (with the workout's prescribed reps and sets) for
+// days logged without sets. Columns: user_id, exercise_id, workout_id,
+// date, weight, reps, duration, sets (how many sets the row stands for)
+// and rpe.
+const loggedPerformances = `(
+		SELECT ws.user_id, w.exercise_id, ws.workout_id, ws.date, ws.weight, ws.reps, ws.duration,
+		       1 AS sets, ws.rpe
+		FROM workout_sets ws
+		JOIN workouts w ON w.id = ws.workout_id
+		WHERE NOT ws.is_warmup
+		UNION ALL
+		SELECT up.user_id, w.exercise_id, up.workout_id, up.date, up.weight, w.reps, up.time,
+		       COALESCE(w.sets, 1), NULL
+		FROM user_progress up
+		JOIN workouts w ON w.id = up.workout_id
+		WHERE NOT EXISTS (
+		    SELECT 1 FROM workout_sets ws
+		    WHERE ws.user_id = up.user_id AND ws.workout_id = up.workout_id AND ws.date = up.date
+		)
+	) p`
+
+// performancesQuery selects a user's performances for an exercise. $1 is
+// the user and $2 the exercise; it ends in AND for the caller to append a
+// condition on p.date and p.workout_id.
+const performancesQuery = `
+	SELECT p.weight, p.reps, p.duration FROM ` + loggedPerformances + `
+	WHERE p.user_id = $1 AND p.exercise_id = $2 AND `
+
+func loadPerformances(q queryer, query string, args ...interface{}) ([]performance, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var performances []performance
+	for rows.Next() {
+		p, err := scanPerformance(rows)
+		if err != nil {
+			return nil, err
+		}
+		performances = append(performances, p)
+	}
+	return performances, rows.Err()
+}
+
+// scanPerformance scans the leading columns into dest, then weight, reps
+// and duration.
+func scanPerformance(row interface{ Scan(...interface{}) error }, dest ...interface{}) (performance, error) {
+	var weight sql.NullFloat64
+	var reps, duration sql.NullInt64
+	var p performance
+	if err := row.Scan(append(dest, &weight, &reps, &duration)...); err != nil {
+		return p, err
+	}
+
+	if weight.Valid && weight.Float64 > 0 {
+		p.weight = &weight.Float64
+	}
+	if reps.Valid && reps.Int64 > 0 {
+		r := int(reps.Int64)
+		p.reps = &r
+	}
+	if duration.Valid && duration.Int64 > 0 {
+		d := int(duration.Int64)
+		p.duration = &d
+	}
+	return p, nil
+}
+
+// bests holds the best values across a set of performances.
+type bests struct {
+	weight, e1rm *float64
+	duration     *int
+	// perf behind each best, reported with the record
+	weightPerf, e1rmPerf performance
+}
+
+func bestOf(performances []performance) bests {
+	var b bests
+	for _, p := range performances {
+		if p.weight != nil && (b.weight == nil || *p.weight > *b.weight) {
+			b.weight, b.weightPerf = p.weight, p
+		}
+		if p.duration != nil && (b.duration == nil || *p.duration > *b.duration) {
+			b.duration = p.duration
+		}
+		if p.weight != nil && p.reps != nil {
+			e, ok := Epley.Estimate(*p.weight, *p.reps)
+			if ok && (b.e1rm == nil || e > *b.e1rm) {
+				b.e1rm, b.e1rmPerf = &e, p
+			}
+		}
+	}
+	return b
+}
+
+// mostRepsAt returns the most reps performed at weight or heavier.
+func mostRepsAt(performances []performance, weight float64) *int {
+	var most *int
+	for _, p := range performances {
+		if p.weight != nil && p.reps != nil && *p.weight >= weight && (most == nil || *p.reps > *most) {
+			most = p.reps
+		}
+	}
+	return most
+}
+
+// compareRecords finds the records set by today's performances. A record
+// needs a previous best to beat, so the first log of an exercise only
+// establishes a baseline.
+func compareRecords(today, history []performance) []PersonalRecord {
+	var records []PersonalRecord
+	now, prev := bestOf(today), bestOf(history)
+
+	if now.weight != nil && prev.weight != nil && *now.weight > *prev.weight {
+		records = append(records, PersonalRecord{Type: RecordWeight, Value: *now.weight,
+			PreviousValue: prev.weight, Weight: now.weightPerf.weight, Reps: now.weightPerf.reps})
+	}
+	if now.duration != nil && prev.duration != nil && *now.duration > *prev.duration {
+		v, p := float64(*now.duration), float64(*prev.duration)
+		records = append(records, PersonalRecord{Type: RecordTime, Value: v, PreviousValue: &p})
+	}
+	if now.e1rm != nil && prev.e1rm != nil && *now.e1rm > *prev.e1rm {
+		records = append(records, PersonalRecord{Type: RecordE1RM, Value: *now.e1rm,
+			PreviousValue: prev.e1rm, Weight: now.e1rmPerf.weight, Reps: now.e1rmPerf.reps})
+	}
+
+	// Rep-max: more reps than ever at this weight or heavier. Only the most
+	// reps at each weight today is considered.
+	repsAt := map[float64]*int{}
+	for _, p := range today {
+		if p.weight == nil || p.reps == nil {
+			continue
+		}
+		if most := repsAt[*p.weight]; most == nil || *p.reps > *most {
+			repsAt[*p.weight] = p.reps
+		}
+	}
+	for weight, reps := range repsAt {
+		previous := mostRepsAt(history, weight)
+		if previous == nil || *reps <= *previous {
+			continue
+		}
+		w, p := weight, float64(*previous)
+		records = append(records, PersonalRecord{Type: RecordRepMax, Value: float64(*reps),
+			PreviousValue: &p, Weight: &w, Reps: reps})
+	}
+	return records
+}
+
+// detectRecords recomputes the personal records a workout set on date and
+// stores them, replacing any found earlier that day, and returns them.
+// Performances are compared with everything the user logged for the same
+// exercise before that day, or on the same day under another workout. A
+// backdated or edited log changes what later days had to beat, so their
+// records are recomputed as well.
+func detectRecords(q queryer, userID, workoutID, date string) ([]PersonalRecord, error) {
+	var exerciseID sql.NullString
+	if err := q.QueryRow(`SELECT exercise_id FROM workouts WHERE id = $1`, workoutID).Scan(&exerciseID); err != nil {
+		return nil, err
+	}
+	if !exerciseID.Valid {
+		_, err := q.Exec(`DELETE FROM personal_records WHERE user_id = $1 AND workout_id = $2 AND date = $3`,
+			userID, workoutID, date)
+		return []PersonalRecord{}, err
+	}
+
+	records, err := storeRecords(q, userID, exerciseID.String, workoutID, date)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(`
+		SELECT DISTINCT p.workout_id, p.date::text FROM `+loggedPerformances+`
+		WHERE p.user_id = $1 AND p.exercise_id = $2
+		  AND (p.date > $4 OR (p.date = $4 AND p.workout_id <> $3))
+		ORDER BY 2, 1`, userID, exerciseID.String, workoutID, date)
+	if err != nil {
+		return nil, err
+	}
+	type logged struct{ workoutID, date string }
+	var later []logged
+	for rows.Next() {
+		var l logged
+		if err := rows.Scan(&l.workoutID, &l.date); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		later = append(later, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, l := range later {
+		if _, err := storeRecords(q, userID, exerciseID.String, l.workoutID, l.date); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// storeRecords replaces the records a workout set on date with the ones its
+// performances set against the user's history for the exercise.
+func storeRecords(q queryer, userID, exerciseID, workoutID, date string) ([]PersonalRecord, error) {
+	records := []PersonalRecord{}
+
+	_, err := q.Exec(`DELETE FROM personal_records WHERE user_id = $1 AND workout_id = $2 AND date = $3`,
+		userID, workoutID, date)
+	if err != nil {
+		return nil, err
+	}
+
+	today, err := loadPerformances(q, performancesQuery+`
+		p.workout_id = $3 AND p.date = $4`, userID, exerciseID, workoutID, date)
+	if err != nil {
+		return nil, err
+	}
+	history, err := loadPerformances(q, performancesQuery+`
+		(p.date < $4 OR (p.date = $4 AND p.workout_id <> $3))`, userID, exerciseID, workoutID, date)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range compareRecords(today, history) {
+		record.UserID, record.ExerciseID, record.WorkoutID, record.Date = userID, exerciseID, workoutID, date
+		err := q.QueryRow(`
+			INSERT INTO personal_records (user_id, exercise_id, workout_id, record_type, value, previous_value,
+			                              weight, reps, date)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING id, created_at`,
+			userID, exerciseID, workoutID, record.Type, record.Value, record.PreviousValue,
+			record.Weight, record.Reps, date).Scan(&record.ID, &record.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// GetUserRecords lists a user's personal record events, newest first.
+// Optional filters: exercise_id (id or slug) and type. With current=true
+// only the standing best of each type per exercise is returned.
+func (db *DB) GetUserRecords(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+	params := r.URL.Query()
+
+	recordType := params.Get("type")
+	if recordType != "" && !RecordType(recordType).Valid() {
+		http.Error(w, "type must be one of weight, time, e1rm, rep_max", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		SELECT pr.id, pr.user_id, pr.exercise_id, e.name, pr.workout_id, pr.record_type, pr.value,
+		       pr.previous_value, pr.weight, pr.reps, pr.date::text, pr.created_at
+		FROM personal_records pr
+		JOIN exercises e ON e.id = pr.exercise_id
+		WHERE pr.user_id = $1
+		  AND ($2 = '' OR e.id::text = $2 OR e.slug = $2)
+		  AND ($3 = '' OR pr.record_type = $3)`
+	if params.Get("current") == "true" {
+		// Rep-max records are per weight, so each weight keeps its own best
+		query = `SELECT DISTINCT ON (exercise_id, record_type, CASE WHEN record_type = 'rep_max' THEN weight END) *
+			FROM (` + query + `) records
+			ORDER BY exercise_id, record_type, CASE WHEN record_type = 'rep_max' THEN weight END, value DESC, date DESC`
+	}
+	query = `SELECT * FROM (` + query + `) ordered ORDER BY date DESC, created_at DESC`
+
+	rows, err := db.Query(query, userID, params.Get("exercise_id"), recordType)
+	if err != nil {
+		log.Printf("Error loading records: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	records := []PersonalRecord{}
+	for rows.Next() {
+		var pr PersonalRecord
+		var previous, weight sql.NullFloat64
+		var reps sql.NullInt64
+
+		err := rows.Scan(&pr.ID, &pr.UserID, &pr.ExerciseID, &pr.ExerciseName, &pr.WorkoutID, &pr.Type,
+			&pr.Value, &previous, &weight, &reps, &pr.Date, &pr.CreatedAt)
+		if err != nil {
+			continue
+		}
+		if previous.Valid {
+			pr.PreviousValue = &previous.Float64
+		}
+		if weight.Valid {
+			pr.Weight = &weight.Float64
+		}
+		if reps.Valid {
+			v := int(reps.Int64)
+			pr.Reps = &v
+		}
+		pr.inUnit(unit)
+		records = append(records, pr)
+	}
+
+	writeJSON(w, http.StatusOK, records)
+}
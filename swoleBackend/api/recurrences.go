@@ -0,0 +1,419 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Recurrences plan a routine by RRULE (see rrule.go) instead of by weekday.
+// Their occurrences are added to the week and day schedules on top of the
+// week schedule or program, and can be listed for any date range.
+
+// maxOccurrenceDays bounds the range of an occurrences request.
+const maxOccurrenceDays = 366
+
+// recurrenceQuery includes recurrences of retired routines so their owners
+// can still see and remove them; they have no occurrences.
+const recurrenceQuery = `
+	SELECT rr.id, rr.user_id, rr.routine_id, COALESCE(r.name, ''), rr.rrule, rr.start_date::text, rr.exceptions::text[],
+	       rr.created_at, rr.updated_at
+	FROM routine_recurrences rr
+	LEFT JOIN routines r ON r.id = rr.routine_id`
+
+func scanRecurrence(row interface{ Scan(...interface{}) error }) (*RoutineRecurrence, error) {
+	var rec RoutineRecurrence
+	err := row.Scan(&rec.ID, &rec.UserID, &rec.RoutineID, &rec.RoutineName, &rec.RRule, &rec.StartDate,
+		pq.Array(&rec.Exceptions), &rec.CreatedAt, &rec.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Exceptions == nil {
+		rec.Exceptions = []string{}
+	}
+	return &rec, nil
+}
+
+// check validates a recurrence, normalizing its rule and sorting its
+// exceptions.
+func (rec *RoutineRecurrence) check() error {
+	if rec.RoutineID == "" {
+		return fmt.Errorf("routine_id is required")
+	}
+	rec.RRule = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(rec.RRule)), "RRULE:")
+	if _, err := parseRRule(rec.RRule); err != nil {
+		return fmt.Errorf("invalid rrule: %v", err)
+	}
+	if _, err := time.Parse(dateLayout, rec.StartDate); err != nil {
+		return fmt.Errorf("invalid start_date %q, expected YYYY-MM-DD", rec.StartDate)
+	}
+
+	seen := make(map[string]bool, len(rec.Exceptions))
+	exceptions := []string{}
+	for _, date := range rec.Exceptions {
+		if _, err := time.Parse(dateLayout, date); err != nil {
+			return fmt.Errorf("invalid exception %q, expected YYYY-MM-DD", date)
+		}
+		if !seen[date] {
+			seen[date] = true
+			exceptions = append(exceptions, date)
+		}
+	}
+	sort.Strings(exceptions)
+	rec.Exceptions = exceptions
+	return nil
+}
+
+// between returns the days from from to to (inclusive) the recurrence
+// falls on, leaving out its exceptions.
+func (rec *RoutineRecurrence) between(from, to time.Time) ([]time.Time, error) {
+	rule, err := parseRRule(rec.RRule)
+	if err != nil {
+		return nil, err
+	}
+	start, err := time.Parse(dateLayout, rec.StartDate)
+	if err != nil {
+		return nil, err
+	}
+
+	skip := make(map[string]bool, len(rec.Exceptions))
+	for _, date := range rec.Exceptions {
+		skip[date] = true
+	}
+	var dates []time.Time
+	for _, d := range rule.between(start, from, to) {
+		if !skip[d.Format(dateLayout)] {
+			dates = append(dates, d)
+		}
+	}
+	return dates, nil
+}
+
+// occurrences returns the days a user's recurrences fall on from from to to
+// (YYYY-MM-DD, inclusive), by date and then routine name.
+func (db *DB) occurrences(userID, from, to string) ([]Occurrence, error) {
+	fromDay, err := time.Parse(dateLayout, from)
+	if err != nil {
+		return nil, err
+	}
+	toDay, err := time.Parse(dateLayout, to)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(recurrenceQuery+`
+		WHERE rr.user_id = $1 AND rr.start_date <= $2 AND r.retired_at IS NULL`, userID, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	occurrences := []Occurrence{}
+	for rows.Next() {
+		rec, err := scanRecurrence(rows)
+		if err != nil {
+			return nil, err
+		}
+		dates, err := rec.between(fromDay, toDay)
+		if err != nil {
+			// Rules are checked when they are saved
+			log.Printf("Error expanding recurrence %s: %v", rec.ID, err)
+			continue
+		}
+		for _, d := range dates {
+			occurrences = append(occurrences, Occurrence{
+				Date:         d.Format(dateLayout),
+				RecurrenceID: rec.ID,
+				RoutineID:    rec.RoutineID,
+				RoutineName:  rec.RoutineName,
+			})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		if occurrences[i].Date != occurrences[j].Date {
+			return occurrences[i].Date < occurrences[j].Date
+		}
+		return occurrences[i].RoutineName < occurrences[j].RoutineName
+	})
+	return occurrences, nil
+}
+
+// addOccurrences adds the routines a user's recurrences plan in the week
+// starting on start to its days, unless the day already has the routine.
+func (db *DB) addOccurrences(week *WeekSchedule, userID, identifier, start, date string) error {
+	first, err := time.Parse(dateLayout, start)
+	if err != nil {
+		return err
+	}
+	occurrences, err := db.occurrences(userID, start, first.AddDate(0, 0, 6).Format(dateLayout))
+	if err != nil {
+		return err
+	}
+
+	for _, o := range occurrences {
+		d, _ := time.Parse(dateLayout, o.Date)
+		day := &week.Schedule[(int(d.Weekday())+6)%7]
+		planned := false
+		for _, routine := range day.Routines {
+			planned = planned || routine.ID == o.RoutineID
+		}
+		if planned {
+			continue
+		}
+
+		routine, err := db.loadRoutineWithProgress(o.RoutineID, identifier, date)
+		if err != nil {
+			return err
+		}
+		recurrenceID := o.RecurrenceID
+		routine.RecurrenceID = &recurrenceID
+		day.Routines = append(day.Routines, *routine)
+	}
+	return nil
+}
+
+// requireOwnRecurrence loads a recurrence for a change by userID, writing a
+// 404 or 403 if it doesn't exist or belongs to someone else.
+func (db *DB) requireOwnRecurrence(w http.ResponseWriter, recurrenceID, userID string) (*RoutineRecurrence, bool) {
+	rec, err := scanRecurrence(db.QueryRow(recurrenceQuery+` WHERE rr.id::text = $1`, recurrenceID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Recurrence not found", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if rec.UserID != userID {
+		http.Error(w, "Recurrence belongs to another user", http.StatusForbidden)
+		return nil, false
+	}
+	return rec, true
+}
+
+// saveRecurrence checks rec and its routine, writing a 400 if either is
+// invalid.
+func (db *DB) saveRecurrence(w http.ResponseWriter, rec *RoutineRecurrence) bool {
+	if err := rec.check(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if err := routinesExist(db, []string{rec.RoutineID}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (db *DB) writeRecurrence(w http.ResponseWriter, status int, recurrenceID string) {
+	rec, err := scanRecurrence(db.QueryRow(recurrenceQuery+` WHERE rr.id = $1`, recurrenceID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, status, rec)
+}
+
+// CreateRecurrence plans a routine for a user by RRULE from start_date
+// (default today in their timezone).
+func (db *DB) CreateRecurrence(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	var rec RoutineRecurrence
+	if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rec.StartDate == "" {
+		loc, err := db.userLocation(userID, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rec.StartDate = time.Now().In(loc).Format(dateLayout)
+	}
+	if !db.saveRecurrence(w, &rec) {
+		return
+	}
+
+	var id string
+	err := db.QueryRow(`
+		INSERT INTO routine_recurrences (user_id, routine_id, rrule, start_date, exceptions)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`, userID, rec.RoutineID, rec.RRule, rec.StartDate, pq.Array(rec.Exceptions)).Scan(&id)
+	if err != nil {
+		log.Printf("Error creating recurrence: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	db.writeRecurrence(w, http.StatusCreated, id)
+}
+
+func (db *DB) GetRecurrences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	rows, err := db.Query(recurrenceQuery+` WHERE rr.user_id = $1 ORDER BY rr.start_date, rr.created_at`, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	recurrences := []RoutineRecurrence{}
+	for rows.Next() {
+		rec, err := scanRecurrence(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recurrences = append(recurrences, *rec)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, recurrences)
+}
+
+func (db *DB) GetRecurrence(w http.ResponseWriter, r *http.Request) {
+	rec, err := scanRecurrence(db.QueryRow(recurrenceQuery+` WHERE rr.id::text = $1`, mux.Vars(r)["id"]))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Recurrence not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, rec)
+}
+
+// UpdateRecurrence changes a recurrence's routine, rule, start date or
+// exceptions; omitted fields are kept.
+func (db *DB) UpdateRecurrence(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	rec, ok := db.requireOwnRecurrence(w, mux.Vars(r)["id"], userID)
+	if !ok {
+		return
+	}
+
+	// Only these fields may change; the id and owner stay the ones checked
+	var in struct {
+		RoutineID  *string   `json:"routine_id"`
+		RRule      *string   `json:"rrule"`
+		StartDate  *string   `json:"start_date"`
+		Exceptions *[]string `json:"exceptions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if in.RoutineID != nil {
+		rec.RoutineID = *in.RoutineID
+	}
+	if in.RRule != nil {
+		rec.RRule = *in.RRule
+	}
+	if in.StartDate != nil {
+		rec.StartDate = *in.StartDate
+	}
+	if in.Exceptions != nil {
+		rec.Exceptions = *in.Exceptions
+	}
+	if !db.saveRecurrence(w, rec) {
+		return
+	}
+
+	_, err := db.Exec(`
+		UPDATE routine_recurrences
+		SET routine_id = $2, rrule = $3, start_date = $4, exceptions = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, rec.ID, rec.RoutineID, rec.RRule, rec.StartDate, pq.Array(rec.Exceptions))
+	if err != nil {
+		log.Printf("Error updating recurrence: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	db.writeRecurrence(w, http.StatusOK, rec.ID)
+}
+
+func (db *DB) DeleteRecurrence(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	rec, ok := db.requireOwnRecurrence(w, mux.Vars(r)["id"], userID)
+	if !ok {
+		return
+	}
+
+	if _, err := db.Exec(`DELETE FROM routine_recurrences WHERE id = $1`, rec.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetOccurrences lists the days a user's recurrences fall on from from
+// (default today) to to (default a week later), at most a year apart.
+func (db *DB) GetOccurrences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	params := r.URL.Query()
+
+	from := params.Get("from")
+	if from == "" {
+		loc, err := db.userLocation(userID, params.Get("timezone"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from = time.Now().In(loc).Format(dateLayout)
+	}
+	fromDay, err := time.Parse(dateLayout, from)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid from %q, expected YYYY-MM-DD", from), http.StatusBadRequest)
+		return
+	}
+	to := params.Get("to")
+	if to == "" {
+		to = fromDay.AddDate(0, 0, 6).Format(dateLayout)
+	}
+	toDay, err := time.Parse(dateLayout, to)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid to %q, expected YYYY-MM-DD", to), http.StatusBadRequest)
+		return
+	}
+	if toDay.Before(fromDay) || toDay.Sub(fromDay) >= maxOccurrenceDays*24*time.Hour {
+		http.Error(w, fmt.Sprintf("to must be on or after from and at most %d days later", maxOccurrenceDays-1),
+			http.StatusBadRequest)
+		return
+	}
+
+	occurrences, err := db.occurrences(userID, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, occurrences)
+}
@@ -0,0 +1,333 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// A rotation is for users who train in sequence rather than on fixed
+// weekdays: the routine up next is the one after the last they finished.
+// Finishing a session moves the user's rotation past the session's routine.
+// rotations.position stores the rotation_routines.position up next, so
+// retiring a routine skips it without moving the others' place; the API's
+// position is an index into the live routines.
+
+// rotationEntry is a live routine of a rotation at its stored position.
+type rotationEntry struct {
+	position int
+	routine  RotationRoutine
+}
+
+// rotationEntries returns the live routines of a rotation in order.
+func rotationEntries(q queryer, rotationID string) ([]rotationEntry, error) {
+	rows, err := q.Query(`
+		SELECT rr.position, r.id, r.name FROM rotation_routines rr
+		JOIN routines r ON r.id = rr.routine_id AND r.retired_at IS NULL
+		WHERE rr.rotation_id = $1
+		ORDER BY rr.position`, rotationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []rotationEntry
+	for rows.Next() {
+		var e rotationEntry
+		if err := rows.Scan(&e.position, &e.routine.ID, &e.routine.Name); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// nextEntry returns the index of the entry up next for a stored position:
+// the first at or after it, wrapping around past retired routines at the
+// end.
+func nextEntry(entries []rotationEntry, position int) int {
+	for i, e := range entries {
+		if e.position >= position {
+			return i
+		}
+	}
+	return 0
+}
+
+func (db *DB) loadRotation(userID string) (*Rotation, error) {
+	var rot Rotation
+	var name sql.NullString
+	err := db.QueryRow(`
+		SELECT id, user_id, name, position, version, created_at, updated_at
+		FROM rotations WHERE user_id = $1`, userID).
+		Scan(&rot.ID, &rot.UserID, &name, &rot.Position, &rot.Version, &rot.CreatedAt, &rot.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if name.Valid {
+		rot.Name = &name.String
+	}
+
+	entries, err := rotationEntries(db, rot.ID)
+	if err != nil {
+		return nil, err
+	}
+	rot.Routines = []RotationRoutine{}
+	for _, e := range entries {
+		rot.Routines = append(rot.Routines, e.routine)
+	}
+	rot.Position = nextEntry(entries, rot.Position)
+	return &rot, nil
+}
+
+// advanceRotation moves a user's rotation past a finished routine: to the
+// routine after its next occurrence from the current position, wrapping
+// around. Routines outside the rotation leave it where it is.
+func advanceRotation(q queryer, userID, routineID string) error {
+	var rotationID string
+	var position int
+	err := q.QueryRow(`SELECT id, position FROM rotations WHERE user_id = $1 FOR UPDATE`, userID).
+		Scan(&rotationID, &position)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	entries, err := rotationEntries(q, rotationID)
+	if err != nil {
+		return err
+	}
+	n := len(entries)
+	next := nextEntry(entries, position)
+	for i := 0; i < n; i++ {
+		if entries[(next+i)%n].routine.ID == routineID {
+			_, err := q.Exec(`
+				UPDATE rotations SET position = $2, version = version + 1, updated_at = CURRENT_TIMESTAMP
+				WHERE id = $1`, rotationID, entries[(next+i+1)%n].position)
+			return err
+		}
+	}
+	return nil
+}
+
+// lockRotation locks a user's rotation for a change. It returns "" if the
+// user has none, and writes a 409 with the current rotation if If-Match is
+// stale.
+func (db *DB) lockRotation(w http.ResponseWriter, r *http.Request, q queryer, userID string) (string, int, bool) {
+	var rotationID string
+	var version, position int
+	err := q.QueryRow(`SELECT id, version, position FROM rotations WHERE user_id = $1 FOR UPDATE`, userID).
+		Scan(&rotationID, &version, &position)
+	if err == sql.ErrNoRows {
+		return "", 0, true
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", 0, false
+	}
+	if !versionMatches(r, version) {
+		current, err := db.loadRotation(userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return "", 0, false
+		}
+		writeVersionConflict(w, version, current)
+		return "", 0, false
+	}
+	return rotationID, position, true
+}
+
+// writeRotation responds with a user's rotation and its ETag.
+func (db *DB) writeRotation(w http.ResponseWriter, status int, userID string) {
+	rot, err := db.loadRotation(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setETag(w, rot.Version)
+	writeJSON(w, status, rot)
+}
+
+func (db *DB) GetRotation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	rot, err := db.loadRotation(userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Rotation not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setETag(w, rot.Version)
+	writeJSON(w, http.StatusOK, rot)
+}
+
+// SetRotation creates or replaces a user's rotation. Without a position, a
+// replaced rotation keeps its place and a new one starts at the first
+// routine.
+func (db *DB) SetRotation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	var in struct {
+		Name       *string  `json:"name"`
+		RoutineIDs []string `json:"routine_ids"`
+		Position   *int     `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(in.RoutineIDs) == 0 {
+		http.Error(w, "routine_ids must list at least one routine", http.StatusBadRequest)
+		return
+	}
+	if in.Position != nil && (*in.Position < 0 || *in.Position >= len(in.RoutineIDs)) {
+		http.Error(w, fmt.Sprintf("position must be between 0 and %d", len(in.RoutineIDs)-1), http.StatusBadRequest)
+		return
+	}
+	if err := routinesExist(db, in.RoutineIDs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	rotationID, position, ok := db.lockRotation(w, r, tx, userID)
+	if !ok {
+		return
+	}
+	if in.Position != nil {
+		position = *in.Position
+	}
+	position %= len(in.RoutineIDs)
+
+	status := http.StatusOK
+	if rotationID == "" {
+		status = http.StatusCreated
+		err = tx.QueryRow(`
+			INSERT INTO rotations (user_id, name, position)
+			VALUES ($1, $2, $3)
+			RETURNING id`, userID, in.Name, position).Scan(&rotationID)
+		if isUniqueViolation(err) {
+			http.Error(w, "Rotation was created by another request", http.StatusConflict)
+			return
+		}
+	} else {
+		_, err = tx.Exec(`
+			UPDATE rotations SET name = $2, position = $3, version = version + 1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1`, rotationID, in.Name, position)
+		if err == nil {
+			_, err = tx.Exec(`DELETE FROM rotation_routines WHERE rotation_id = $1`, rotationID)
+		}
+	}
+	for pos, routineID := range in.RoutineIDs {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec(`
+			INSERT INTO rotation_routines (rotation_id, position, routine_id)
+			VALUES ($1, $2, $3)`, rotationID, pos, routineID)
+	}
+	if err != nil {
+		log.Printf("Error saving rotation: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	db.writeRotation(w, status, userID)
+}
+
+func (db *DB) DeleteRotation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := db.requireUserID(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	rotationID, _, ok := db.lockRotation(w, r, tx, userID)
+	if !ok {
+		return
+	}
+	if rotationID == "" {
+		http.Error(w, "Rotation not found", http.StatusNotFound)
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM rotations WHERE id = $1`, rotationID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetNextRoutine returns the routine up next in a user's rotation, with
+// their progress for the day (?date=, default today) filled in.
+func (db *DB) GetNextRoutine(w http.ResponseWriter, r *http.Request) {
+	identifier := mux.Vars(r)["id"]
+	userID, ok := db.requireUserID(w, identifier)
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+	date, err := db.requestDate(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rot, err := db.loadRotation(userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Rotation not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(rot.Routines) == 0 {
+		http.Error(w, "Rotation has no routines", http.StatusNotFound)
+		return
+	}
+
+	routine, err := db.loadRoutineWithProgress(rot.Routines[rot.Position].ID, userID, date)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	routine.inUnit(unit)
+	writeJSON(w, http.StatusOK, RotationNext{
+		Position: rot.Position,
+		Length:   len(rot.Routines),
+		Routine:  *routine,
+	})
+}
@@ -0,0 +1,723 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// queryer is satisfied by both *DB and *sql.Tx so helpers can run inside
+// or outside a transaction.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (in *WorkoutInput) validate() error {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return errors.New("workout name is required")
+	}
+	if !in.ExerciseType.Valid() {
+		return fmt.Errorf("invalid exerciseType %q", in.ExerciseType)
+	}
+	if in.Type != nil && !in.Type.Valid() {
+		return fmt.Errorf("invalid type %q", *in.Type)
+	}
+	if in.Weight != nil && *in.Weight < 0 {
+		return errors.New("weight must not be negative")
+	}
+	if in.Time != nil && *in.Time < 0 {
+		return errors.New("time must not be negative")
+	}
+	if in.Reps != nil && *in.Reps < 0 {
+		return errors.New("reps must not be negative")
+	}
+	if in.Sets != nil && *in.Sets < 0 {
+		return errors.New("sets must not be negative")
+	}
+	for i, group := range in.MuscleGroups {
+		in.MuscleGroups[i] = strings.ToLower(strings.TrimSpace(group))
+		if in.MuscleGroups[i] == "" {
+			return errors.New("muscleGroups must not contain empty values")
+		}
+	}
+	if in.Progression != nil {
+		return in.Progression.validate(in)
+	}
+	return nil
+}
+
+func (in *RoutineInput) validate() error {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return errors.New("routine name is required")
+	}
+	if in.DurationMinutes != nil && *in.DurationMinutes < 0 {
+		return errors.New("durationMinutes must not be negative")
+	}
+	for i := range in.Workouts {
+		if err := in.Workouts[i].validate(); err != nil {
+			return fmt.Errorf("workouts[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func insertRoutine(q queryer, in RoutineInput) (string, error) {
+	var id string
+	err := q.QueryRow(`
+		INSERT INTO routines (name, description, category, difficulty, duration_minutes)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`,
+		in.Name, in.Description, in.Category, in.Difficulty, in.DurationMinutes).Scan(&id)
+	return id, err
+}
+
+// updateRoutine also restores a retired routine, which only sync reaches.
+func updateRoutine(q queryer, routineID string, in RoutineInput) error {
+	_, err := q.Exec(`
+		UPDATE routines
+		SET name = $2, description = $3, category = $4, difficulty = $5, duration_minutes = $6,
+		    retired_at = NULL, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		routineID, in.Name, in.Description, in.Category, in.Difficulty, in.DurationMinutes)
+	return err
+}
+
+// insertWorkout appends a workout after the routine's current last position.
+func insertWorkout(q queryer, routineID string, in WorkoutInput) (string, error) {
+	var position int
+	err := q.QueryRow(`SELECT COALESCE(MAX(position) + 1, 0) FROM workouts WHERE routine_id = $1`, routineID).
+		Scan(&position)
+	if err != nil {
+		return "", err
+	}
+	return insertWorkoutAt(q, routineID, workoutKey(in.Name), position, in)
+}
+
+var errUnknownExercise = errors.New("unknown exerciseId")
+
+// linkExercise points in at its catalog exercise. An explicit ExerciseID
+// must already exist; otherwise the exercise identified by slug (derived
+// from the workout name when empty) is used, creating it on first use.
+func linkExercise(q queryer, in *WorkoutInput, slug string) error {
+	if in.ExerciseID != nil {
+		var id string
+		err := q.QueryRow(`SELECT id FROM exercises WHERE id::text = $1`, *in.ExerciseID).Scan(&id)
+		if err == sql.ErrNoRows {
+			return errUnknownExercise
+		}
+		return err
+	}
+
+	if slug == "" {
+		slug = workoutKey(in.Name)
+	}
+	var id string
+	err := q.QueryRow(`
+		INSERT INTO exercises (slug, name, type, exercise_type)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (slug) DO UPDATE SET slug = EXCLUDED.slug
+		RETURNING id`, slug, in.Name, in.Type, in.ExerciseType).Scan(&id)
+	if err != nil {
+		return err
+	}
+	in.ExerciseID = &id
+	return nil
+}
+
+func insertWorkoutAt(q queryer, routineID, key string, position int, in WorkoutInput) (string, error) {
+	if err := linkExercise(q, &in, ""); err != nil {
+		return "", err
+	}
+
+	var id string
+	err := q.QueryRow(`
+		INSERT INTO workouts (routine_id, sync_key, position, exercise_id, name, type, exercise_type, weight,
+		                      time, reps, sets, description, instructions, muscle_groups, progression)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING id`,
+		routineID, key, position, in.ExerciseID, in.Name, in.Type, in.ExerciseType, in.Weight,
+		in.Time, in.Reps, in.Sets, in.Description, in.Instructions, pq.Array(in.MuscleGroups),
+		in.Progression).Scan(&id)
+	return id, err
+}
+
+func updateWorkout(q queryer, workoutID string, in WorkoutInput) error {
+	if err := linkExercise(q, &in, ""); err != nil {
+		return err
+	}
+
+	_, err := q.Exec(`
+		UPDATE workouts
+		SET exercise_id = $2, name = $3, type = $4, exercise_type = $5, weight = $6, time = $7, reps = $8,
+		    sets = $9, description = $10, instructions = $11, muscle_groups = $12, progression = $13,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		workoutID, in.ExerciseID, in.Name, in.Type, in.ExerciseType, in.Weight, in.Time, in.Reps,
+		in.Sets, in.Description, in.Instructions, pq.Array(in.MuscleGroups), in.Progression)
+	return err
+}
+
+func (db *DB) loadRoutine(routineID string) (*Routine, error) {
+	var routine Routine
+	var description sql.NullString
+	var meta routineMeta
+
+	err := db.QueryRow(`
+		SELECT id, name, description, category, difficulty, duration_minutes, version, created_at, updated_at
+		FROM routines WHERE id::text = $1 AND retired_at IS NULL`, routineID).
+		Scan(&routine.ID, &routine.Name, &description, &meta.category, &meta.difficulty, &meta.duration,
+			&routine.Version, &routine.CreatedAt, &routine.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if description.Valid {
+		routine.Description = &description.String
+	}
+	meta.apply(&routine)
+	routine.Workouts = db.getWorkoutsForRoutine(routine.ID)
+	if routine.Workouts == nil {
+		routine.Workouts = []Workout{}
+	}
+	return &routine, nil
+}
+
+// loadRoutineWithProgress loads a routine with the user's progress for date
+// (or their today when date is empty) filled in.
+func (db *DB) loadRoutineWithProgress(routineID, userID, date string) (*Routine, error) {
+	routine, err := db.loadRoutine(routineID)
+	if err != nil {
+		return nil, err
+	}
+	routine.Workouts = db.getWorkoutsForRoutineWithProgress(routine.ID, userID, date)
+	if routine.Workouts == nil {
+		routine.Workouts = []Workout{}
+	}
+	return routine, nil
+}
+
+func (db *DB) loadWorkoutInput(routineID, workoutID string) (*WorkoutInput, error) {
+	var in WorkoutInput
+	var exerciseID, workoutType, description, instructions sql.NullString
+	var weight sql.NullFloat64
+	var t, reps, sets sql.NullInt64
+	var progression []byte
+
+	err := db.QueryRow(`
+		SELECT exercise_id, name, type, exercise_type, weight, time, reps, sets, description, instructions,
+		       muscle_groups, progression
+		FROM workouts
+		WHERE id::text = $1 AND routine_id::text = $2 AND retired_at IS NULL`, workoutID, routineID).
+		Scan(&exerciseID, &in.Name, &workoutType, &in.ExerciseType, &weight, &t, &reps, &sets, &description,
+			&instructions, pq.Array(&in.MuscleGroups), &progression)
+	if err != nil {
+		return nil, err
+	}
+	if in.Progression, err = parseProgression(progression); err != nil {
+		return nil, err
+	}
+
+	if exerciseID.Valid {
+		in.ExerciseID = &exerciseID.String
+	}
+	if workoutType.Valid {
+		wt := WorkoutType(workoutType.String)
+		in.Type = &wt
+	}
+	if weight.Valid {
+		in.Weight = &weight.Float64
+	}
+	if t.Valid {
+		v := int(t.Int64)
+		in.Time = &v
+	}
+	if reps.Valid {
+		v := int(reps.Int64)
+		in.Reps = &v
+	}
+	if sets.Valid {
+		v := int(sets.Int64)
+		in.Sets = &v
+	}
+	if description.Valid {
+		in.Description = &description.String
+	}
+	if instructions.Valid {
+		in.Instructions = &instructions.String
+	}
+	return &in, nil
+}
+
+func (db *DB) loadWorkout(routineID, workoutID string) (*Workout, error) {
+	for _, w := range db.getWorkoutsForRoutine(routineID) {
+		if w.ID == workoutID {
+			return &w, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// requestUnit resolves the weight unit of a routine request, which names
+// the user, if at all, with ?user_id=.
+func (db *DB) requestUnit(w http.ResponseWriter, r *http.Request) (WeightUnit, bool) {
+	return db.requireUnit(w, r, r.URL.Query().Get("user_id"))
+}
+
+func (db *DB) CreateRoutine(w http.ResponseWriter, r *http.Request) {
+	unit, ok := db.requestUnit(w, r)
+	if !ok {
+		return
+	}
+	var in RoutineInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := in.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in.toPounds(unit)
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	routineID, err := insertRoutine(tx, in)
+	if err != nil {
+		if isUniqueViolation(err) {
+			http.Error(w, "A routine with that name already exists", http.StatusConflict)
+			return
+		}
+		log.Printf("Error creating routine: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, workout := range in.Workouts {
+		if _, err := insertWorkout(tx, routineID, workout); err == errUnknownExercise {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Printf("Error creating workout: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	routine, err := db.loadRoutine(routineID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	routine.inUnit(unit)
+	setETag(w, routine.Version)
+	writeJSON(w, http.StatusCreated, routine)
+}
+
+// UpdateRoutine handles both PUT (full replacement) and PATCH (only the
+// fields present in the body are changed).
+func (db *DB) UpdateRoutine(w http.ResponseWriter, r *http.Request) {
+	routineID := mux.Vars(r)["id"]
+	unit, ok := db.requestUnit(w, r)
+	if !ok {
+		return
+	}
+
+	current, err := db.loadRoutine(routineID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Routine not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var in RoutineInput
+	if r.Method == http.MethodPatch {
+		in.Name = current.Name
+		in.Description = current.Description
+		in.Category = current.Category
+		in.Difficulty = current.Difficulty
+		in.DurationMinutes = current.DurationMinutes
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(in.Workouts) > 0 {
+		http.Error(w, "workouts must be managed via /routines/{id}/workouts", http.StatusBadRequest)
+		return
+	}
+	if err := in.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, ok := db.lockRoutine(w, r, tx, current.ID); !ok {
+		return
+	}
+	if err := updateRoutine(tx, current.ID, in); err != nil {
+		if isUniqueViolation(err) {
+			http.Error(w, "A routine with that name already exists", http.StatusConflict)
+			return
+		}
+		log.Printf("Error updating routine: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	routine, err := db.loadRoutine(current.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	routine.inUnit(unit)
+	setETag(w, routine.Version)
+	writeJSON(w, http.StatusOK, routine)
+}
+
+func (db *DB) DeleteRoutine(w http.ResponseWriter, r *http.Request) {
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	routineID, ok := db.lockRoutine(w, r, tx, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	_, err = tx.Exec(`
+		UPDATE routines SET retired_at = CURRENT_TIMESTAMP, version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, routineID)
+	if err != nil {
+		log.Printf("Error deleting routine: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (db *DB) GetRoutineWorkouts(w http.ResponseWriter, r *http.Request) {
+	routineID := mux.Vars(r)["id"]
+	unit, ok := db.requestUnit(w, r)
+	if !ok {
+		return
+	}
+
+	routine, err := db.loadRoutine(routineID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Routine not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	routine.inUnit(unit)
+	writeJSON(w, http.StatusOK, routine.Workouts)
+}
+
+func (db *DB) CreateRoutineWorkout(w http.ResponseWriter, r *http.Request) {
+	routineID := mux.Vars(r)["id"]
+	unit, ok := db.requestUnit(w, r)
+	if !ok {
+		return
+	}
+
+	routine, err := db.loadRoutine(routineID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Routine not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var in WorkoutInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := in.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in.toPounds(unit)
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, ok := db.lockRoutine(w, r, tx, routine.ID); !ok {
+		return
+	}
+	workoutID, err := insertWorkout(tx, routine.ID, in)
+	if err == errUnknownExercise {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Printf("Error creating workout: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	version, err := touchRoutine(tx, routine.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	workout, err := db.loadWorkout(routine.ID, workoutID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	workout.inUnit(unit)
+	setETag(w, version)
+	writeJSON(w, http.StatusCreated, workout)
+}
+
+// UpdateRoutineWorkout handles both PUT and PATCH for a single workout.
+func (db *DB) UpdateRoutineWorkout(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	routineID := vars["id"]
+	workoutID := vars["workoutId"]
+	unit, ok := db.requestUnit(w, r)
+	if !ok {
+		return
+	}
+
+	current, err := db.loadWorkoutInput(routineID, workoutID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Workout not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	in := WorkoutInput{}
+	if r.Method == http.MethodPatch {
+		in = current.shownIn(unit)
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := in.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in.editedFrom(current, unit)
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	routineID, ok = db.lockRoutine(w, r, tx, routineID)
+	if !ok {
+		return
+	}
+	if err := updateWorkout(tx, workoutID, in); err == errUnknownExercise {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Printf("Error updating workout: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	version, err := touchRoutine(tx, routineID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	workout, err := db.loadWorkout(routineID, workoutID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	workout.inUnit(unit)
+	setETag(w, version)
+	writeJSON(w, http.StatusOK, workout)
+}
+
+func (db *DB) DeleteRoutineWorkout(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	routineID, ok := db.lockRoutine(w, r, tx, vars["id"])
+	if !ok {
+		return
+	}
+	res, err := tx.Exec(`
+		UPDATE workouts SET retired_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id::text = $1 AND routine_id = $2 AND retired_at IS NULL`, vars["workoutId"], routineID)
+	if err != nil {
+		log.Printf("Error deleting workout: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Workout not found", http.StatusNotFound)
+		return
+	}
+	version, err := touchRoutine(tx, routineID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setETag(w, version)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReorderRoutineWorkouts sets the order of a routine's workouts. The body
+// must list every workout in the routine exactly once.
+func (db *DB) ReorderRoutineWorkouts(w http.ResponseWriter, r *http.Request) {
+	routineID := mux.Vars(r)["id"]
+	unit, ok := db.requestUnit(w, r)
+	if !ok {
+		return
+	}
+
+	var order struct {
+		WorkoutIDs []string `json:"workoutIds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	routine, err := db.loadRoutine(routineID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Routine not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	existing := make(map[string]bool, len(routine.Workouts))
+	for _, workout := range routine.Workouts {
+		existing[workout.ID] = true
+	}
+	if len(order.WorkoutIDs) != len(existing) {
+		http.Error(w, "workoutIds must list every workout in the routine exactly once", http.StatusBadRequest)
+		return
+	}
+	seen := make(map[string]bool, len(order.WorkoutIDs))
+	for _, id := range order.WorkoutIDs {
+		if !existing[id] || seen[id] {
+			http.Error(w, "workoutIds must list every workout in the routine exactly once", http.StatusBadRequest)
+			return
+		}
+		seen[id] = true
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, ok := db.lockRoutine(w, r, tx, routine.ID); !ok {
+		return
+	}
+	for pos, id := range order.WorkoutIDs {
+		_, err := tx.Exec(`
+			UPDATE workouts SET position = $3, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND routine_id = $2`, id, routine.ID, pos)
+		if err != nil {
+			log.Printf("Error reordering workouts: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if _, err := touchRoutine(tx, routine.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	routine, err = db.loadRoutine(routine.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	routine.inUnit(unit)
+	setETag(w, routine.Version)
+	writeJSON(w, http.StatusOK, routine)
+}
@@ -0,0 +1,361 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence rules follow RFC 5545 (section 3.3.10) at the granularity of
+// days: FREQ (DAILY, WEEKLY, MONTHLY or YEARLY), INTERVAL, COUNT, UNTIL,
+// BYDAY (with ordinals such as 1MO or -1FR for monthly and yearly rules),
+// BYMONTHDAY, BYMONTH and WKST. Parts that only make sense with times of
+// day, BYSETPOS, BYYEARDAY and BYWEEKNO are rejected. Dates are midnight
+// UTC, as time.Parse returns them for dateLayout.
+
+type recurFreq int
+
+const (
+	freqDaily recurFreq = iota
+	freqWeekly
+	freqMonthly
+	freqYearly
+)
+
+// weekdayNum is a BYDAY entry: every such weekday when n is 0, otherwise
+// the nth (or, negative, the nth from last) in the month or year.
+type weekdayNum struct {
+	n   int
+	day time.Weekday
+}
+
+type recurrence struct {
+	freq       recurFreq
+	interval   int
+	count      int
+	until      *time.Time
+	byDay      []weekdayNum
+	byMonthDay []int
+	byMonth    []int
+	weekStart  time.Weekday
+}
+
+var rruleDays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+func parseRRuleInts(name, value string, min, max int, zero bool) ([]int, error) {
+	var ints []int
+	for _, item := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(item)
+		if err != nil || n < min || n > max || (n == 0 && !zero) {
+			return nil, fmt.Errorf("invalid %s value %q", name, item)
+		}
+		ints = append(ints, n)
+	}
+	return ints, nil
+}
+
+// parseRRule parses a recurrence rule, with or without the RRULE: prefix.
+func parseRRule(s string) (*recurrence, error) {
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "RRULE:")
+	r := &recurrence{interval: 1, weekStart: time.Monday}
+	seen := map[string]bool{}
+
+	for _, part := range strings.Split(s, ";") {
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid rule part %q", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s is given twice", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			switch value {
+			case "DAILY":
+				r.freq = freqDaily
+			case "WEEKLY":
+				r.freq = freqWeekly
+			case "MONTHLY":
+				r.freq = freqMonthly
+			case "YEARLY":
+				r.freq = freqYearly
+			default:
+				return nil, fmt.Errorf("FREQ must be DAILY, WEEKLY, MONTHLY or YEARLY, got %q", value)
+			}
+		case "INTERVAL", "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("%s must be a positive number, got %q", name, value)
+			}
+			if name == "INTERVAL" {
+				r.interval = n
+			} else {
+				r.count = n
+			}
+		case "UNTIL":
+			// A date, or a date-time of which only the day counts
+			if len(value) < 8 {
+				return nil, fmt.Errorf("invalid UNTIL %q", value)
+			}
+			until, err := time.Parse("20060102", value[:8])
+			if err != nil {
+				return nil, fmt.Errorf("invalid UNTIL %q", value)
+			}
+			r.until = &until
+		case "BYDAY":
+			for _, item := range strings.Split(value, ",") {
+				if len(item) < 2 {
+					return nil, fmt.Errorf("invalid BYDAY value %q", item)
+				}
+				day, ok := rruleDays[item[len(item)-2:]]
+				if !ok {
+					return nil, fmt.Errorf("invalid BYDAY value %q", item)
+				}
+				wd := weekdayNum{day: day}
+				if prefix := item[:len(item)-2]; prefix != "" {
+					wd.n, err = strconv.Atoi(prefix)
+					if err != nil || wd.n == 0 || wd.n < -53 || wd.n > 53 {
+						return nil, fmt.Errorf("invalid BYDAY value %q", item)
+					}
+				}
+				r.byDay = append(r.byDay, wd)
+			}
+		case "BYMONTHDAY":
+			r.byMonthDay, err = parseRRuleInts(name, value, -31, 31, false)
+		case "BYMONTH":
+			r.byMonth, err = parseRRuleInts(name, value, 1, 12, false)
+		case "WKST":
+			day, ok := rruleDays[value]
+			if !ok {
+				return nil, fmt.Errorf("invalid WKST %q", value)
+			}
+			r.weekStart = day
+		default:
+			return nil, fmt.Errorf("%s is not supported", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !seen["FREQ"] {
+		return nil, errors.New("FREQ is required")
+	}
+	if r.count > 0 && r.until != nil {
+		return nil, errors.New("COUNT and UNTIL cannot both be given")
+	}
+	if r.freq == freqWeekly && len(r.byMonthDay) > 0 {
+		return nil, errors.New("BYMONTHDAY cannot be used with FREQ=WEEKLY")
+	}
+	if r.freq == freqDaily || r.freq == freqWeekly {
+		for _, wd := range r.byDay {
+			if wd.n != 0 {
+				return nil, errors.New("BYDAY ordinals need FREQ=MONTHLY or FREQ=YEARLY")
+			}
+		}
+	}
+	return r, nil
+}
+
+func containsInt(ints []int, n int) bool {
+	for _, i := range ints {
+		if i == n {
+			return true
+		}
+	}
+	return false
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// matches applies BYMONTH, BYMONTHDAY and BYDAY as filters, as they are
+// for daily rules.
+func (r *recurrence) matches(d time.Time) bool {
+	if len(r.byMonth) > 0 && !containsInt(r.byMonth, int(d.Month())) {
+		return false
+	}
+	if len(r.byMonthDay) > 0 {
+		fromEnd := d.Day() - daysIn(d.Year(), d.Month()) - 1
+		if !containsInt(r.byMonthDay, d.Day()) && !containsInt(r.byMonthDay, fromEnd) {
+			return false
+		}
+	}
+	if len(r.byDay) > 0 {
+		for _, wd := range r.byDay {
+			if wd.day == d.Weekday() {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// nthWeekdays returns the days from first to last (inclusive) that the
+// BYDAY entries select, counting ordinals within that span.
+func (r *recurrence) nthWeekdays(first, last time.Time) map[int]bool {
+	days := map[int]bool{}
+	span := int(last.Sub(first).Hours()/24) + 1
+	for _, wd := range r.byDay {
+		offset := (int(wd.day) - int(first.Weekday()) + 7) % 7
+		var matches []int
+		for i := offset; i < span; i += 7 {
+			matches = append(matches, i)
+		}
+		switch {
+		case wd.n == 0:
+			for _, i := range matches {
+				days[i] = true
+			}
+		case wd.n > 0 && wd.n <= len(matches):
+			days[matches[wd.n-1]] = true
+		case wd.n < 0 && -wd.n <= len(matches):
+			days[matches[len(matches)+wd.n]] = true
+		}
+	}
+	return days
+}
+
+// monthDays returns the days of a month a monthly or yearly rule yields,
+// defaulting to day when neither BYMONTHDAY nor BYDAY is given.
+func (r *recurrence) monthDays(year int, month time.Month, day int) []time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	last := daysIn(year, month)
+
+	var days []int
+	switch {
+	case len(r.byMonthDay) == 0 && len(r.byDay) == 0:
+		if day <= last {
+			days = append(days, day)
+		}
+	default:
+		var byDay map[int]bool
+		if len(r.byDay) > 0 {
+			byDay = r.nthWeekdays(first, first.AddDate(0, 0, last-1))
+		}
+		for d := 1; d <= last; d++ {
+			if len(r.byMonthDay) > 0 && !containsInt(r.byMonthDay, d) && !containsInt(r.byMonthDay, d-last-1) {
+				continue
+			}
+			if byDay != nil && !byDay[d-1] {
+				continue
+			}
+			days = append(days, d)
+		}
+	}
+
+	dates := make([]time.Time, len(days))
+	for i, d := range days {
+		dates[i] = first.AddDate(0, 0, d-1)
+	}
+	return dates
+}
+
+// period returns the candidate dates of the kth period of the rule, in
+// order, and the first day of the period.
+func (r *recurrence) period(start time.Time, k int) ([]time.Time, time.Time) {
+	var dates []time.Time
+	switch r.freq {
+	case freqDaily:
+		d := start.AddDate(0, 0, k*r.interval)
+		if r.matches(d) {
+			dates = append(dates, d)
+		}
+		return dates, d
+
+	case freqWeekly:
+		offset := (int(start.Weekday()) - int(r.weekStart) + 7) % 7
+		first := start.AddDate(0, 0, 7*k*r.interval-offset)
+		for i := 0; i < 7; i++ {
+			d := first.AddDate(0, 0, i)
+			if len(r.byMonth) > 0 && !containsInt(r.byMonth, int(d.Month())) {
+				continue
+			}
+			if len(r.byDay) == 0 && d.Weekday() != start.Weekday() {
+				continue
+			}
+			if len(r.byDay) > 0 && !r.matches(d) {
+				continue
+			}
+			dates = append(dates, d)
+		}
+		return dates, first
+
+	case freqMonthly:
+		first := time.Date(start.Year(), start.Month()+time.Month(k*r.interval), 1, 0, 0, 0, 0, time.UTC)
+		if len(r.byMonth) > 0 && !containsInt(r.byMonth, int(first.Month())) {
+			return nil, first
+		}
+		return r.monthDays(first.Year(), first.Month(), start.Day()), first
+	}
+
+	year := start.Year() + k*r.interval
+	first := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	if len(r.byMonth) == 0 && len(r.byMonthDay) == 0 && len(r.byDay) > 0 {
+		// Weekdays of the whole year, with ordinals counted in the year
+		last := time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC)
+		byDay := r.nthWeekdays(first, last)
+		for i := 0; !first.AddDate(0, 0, i).After(last); i++ {
+			if byDay[i] {
+				dates = append(dates, first.AddDate(0, 0, i))
+			}
+		}
+		return dates, first
+	}
+
+	months := r.byMonth
+	if len(months) == 0 {
+		months = []int{int(start.Month())}
+		if len(r.byMonthDay) > 0 {
+			months = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+		}
+	}
+	sorted := append([]int(nil), months...)
+	sort.Ints(sorted)
+	for _, m := range sorted {
+		dates = append(dates, r.monthDays(year, time.Month(m), start.Day())...)
+	}
+	return dates, first
+}
+
+// between returns the dates from from to to (inclusive) of the series the
+// rule yields from start. COUNT counts from start, not from from.
+func (r *recurrence) between(start, from, to time.Time) []time.Time {
+	var dates []time.Time
+	n := 0
+	for k := 0; ; k++ {
+		candidates, first := r.period(start, k)
+		if first.After(to) || (r.until != nil && first.After(*r.until)) {
+			return dates
+		}
+		for _, d := range candidates {
+			if d.Before(start) {
+				continue
+			}
+			if d.After(to) || (r.until != nil && d.After(*r.until)) {
+				return dates
+			}
+			n++
+			if r.count > 0 && n > r.count {
+				return dates
+			}
+			if !d.Before(from) {
+				dates = append(dates, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustDate(t *testing.T, s string) time.Time {
+	t.Helper()
+	d, err := time.Parse(dateLayout, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// Most cases are the examples of RFC 5545 section 3.8.5.3, with DTSTART
+// reduced to its date. to bounds the series when the rule doesn't.
+func TestRecurrenceBetween(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  string
+		start string
+		from  string // defaults to start
+		to    string
+		want  string
+	}{
+		{
+			name:  "daily for 10 occurrences",
+			rule:  "FREQ=DAILY;COUNT=10",
+			start: "1997-09-02", to: "1998-12-31",
+			want: "1997-09-02 1997-09-03 1997-09-04 1997-09-05 1997-09-06 1997-09-07 1997-09-08 1997-09-09 1997-09-10 1997-09-11",
+		},
+		{
+			name:  "every other day",
+			rule:  "RRULE:FREQ=DAILY;INTERVAL=2",
+			start: "1997-09-02", to: "1997-09-12",
+			want: "1997-09-02 1997-09-04 1997-09-06 1997-09-08 1997-09-10 1997-09-12",
+		},
+		{
+			name:  "every 10 days, 5 occurrences",
+			rule:  "FREQ=DAILY;INTERVAL=10;COUNT=5",
+			start: "1997-09-02", to: "1998-12-31",
+			want: "1997-09-02 1997-09-12 1997-09-22 1997-10-02 1997-10-12",
+		},
+		{
+			name:  "every day in January until 2000",
+			rule:  "FREQ=DAILY;UNTIL=20000131T140000Z;BYMONTH=1",
+			start: "1998-01-01", from: "1999-12-30", to: "2001-12-31",
+			want: "2000-01-01 2000-01-02 2000-01-03 2000-01-04 2000-01-05 2000-01-06 2000-01-07 2000-01-08 " +
+				"2000-01-09 2000-01-10 2000-01-11 2000-01-12 2000-01-13 2000-01-14 2000-01-15 2000-01-16 " +
+				"2000-01-17 2000-01-18 2000-01-19 2000-01-20 2000-01-21 2000-01-22 2000-01-23 2000-01-24 " +
+				"2000-01-25 2000-01-26 2000-01-27 2000-01-28 2000-01-29 2000-01-30 2000-01-31",
+		},
+		{
+			name:  "weekly for 10 occurrences",
+			rule:  "FREQ=WEEKLY;COUNT=10",
+			start: "1997-09-02", to: "1998-12-31",
+			want: "1997-09-02 1997-09-09 1997-09-16 1997-09-23 1997-09-30 1997-10-07 1997-10-14 1997-10-21 1997-10-28 1997-11-04",
+		},
+		{
+			name:  "weekly on Tuesday and Thursday for five weeks",
+			rule:  "FREQ=WEEKLY;COUNT=10;WKST=SU;BYDAY=TU,TH",
+			start: "1997-09-02", to: "1998-12-31",
+			want: "1997-09-02 1997-09-04 1997-09-09 1997-09-11 1997-09-16 1997-09-18 1997-09-23 1997-09-25 1997-09-30 1997-10-02",
+		},
+		{
+			name:  "every other week on Tuesday and Thursday",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;WKST=SU;BYDAY=TU,TH",
+			start: "1997-09-02", to: "1997-10-16",
+			want: "1997-09-02 1997-09-04 1997-09-16 1997-09-18 1997-09-30 1997-10-02 1997-10-14 1997-10-16",
+		},
+		{
+			// The RFC's UNTIL is midnight before the 9 AM occurrence on
+			// the 24th; dates make UNTIL inclusive
+			name:  "every other week on Monday, Wednesday and Friday until December 24",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;UNTIL=19971224T000000Z;WKST=SU;BYDAY=MO,WE,FR",
+			start: "1997-09-01", to: "1998-12-31",
+			want: "1997-09-01 1997-09-03 1997-09-05 1997-09-15 1997-09-17 1997-09-19 1997-09-29 " +
+				"1997-10-01 1997-10-03 1997-10-13 1997-10-15 1997-10-17 1997-10-27 1997-10-29 1997-10-31 " +
+				"1997-11-10 1997-11-12 1997-11-14 1997-11-24 1997-11-26 1997-11-28 " +
+				"1997-12-08 1997-12-10 1997-12-12 1997-12-22 1997-12-24",
+		},
+		{
+			name:  "WKST=MO changes which weeks an interval skips",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=MO",
+			start: "1997-08-05", to: "1998-12-31",
+			want: "1997-08-05 1997-08-10 1997-08-19 1997-08-24",
+		},
+		{
+			name:  "WKST=SU changes which weeks an interval skips",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=SU",
+			start: "1997-08-05", to: "1998-12-31",
+			want: "1997-08-05 1997-08-17 1997-08-19 1997-08-31",
+		},
+		{
+			name:  "monthly on the first Friday for 10 occurrences",
+			rule:  "FREQ=MONTHLY;COUNT=10;BYDAY=1FR",
+			start: "1997-09-05", to: "1998-12-31",
+			want: "1997-09-05 1997-10-03 1997-11-07 1997-12-05 1998-01-02 1998-02-06 1998-03-06 1998-04-03 1998-05-01 1998-06-05",
+		},
+		{
+			name:  "every other month on the first and last Sunday",
+			rule:  "FREQ=MONTHLY;INTERVAL=2;COUNT=10;BYDAY=1SU,-1SU",
+			start: "1997-09-07", to: "1998-12-31",
+			want: "1997-09-07 1997-09-28 1997-11-02 1997-11-30 1998-01-04 1998-01-25 1998-03-01 1998-03-29 1998-05-03 1998-05-31",
+		},
+		{
+			name:  "monthly on the second-to-last Monday for 6 months",
+			rule:  "FREQ=MONTHLY;COUNT=6;BYDAY=-2MO",
+			start: "1997-09-22", to: "1998-12-31",
+			want: "1997-09-22 1997-10-20 1997-11-17 1997-12-22 1998-01-19 1998-02-16",
+		},
+		{
+			name:  "monthly on the last Friday",
+			rule:  "FREQ=MONTHLY;BYDAY=-1FR",
+			start: "2026-01-01", to: "2026-04-30",
+			want: "2026-01-30 2026-02-27 2026-03-27 2026-04-24",
+		},
+		{
+			name:  "monthly on the third-to-last day",
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=-3",
+			start: "1997-09-28", to: "1998-02-28",
+			want: "1997-09-28 1997-10-29 1997-11-28 1997-12-29 1998-01-29 1998-02-26",
+		},
+		{
+			name:  "monthly on the 2nd and 15th for 10 occurrences",
+			rule:  "FREQ=MONTHLY;COUNT=10;BYMONTHDAY=2,15",
+			start: "1997-09-02", to: "1998-12-31",
+			want: "1997-09-02 1997-09-15 1997-10-02 1997-10-15 1997-11-02 1997-11-15 1997-12-02 1997-12-15 1998-01-02 1998-01-15",
+		},
+		{
+			name:  "monthly on the first and last day for 10 occurrences",
+			rule:  "FREQ=MONTHLY;COUNT=10;BYMONTHDAY=1,-1",
+			start: "1997-09-30", to: "1998-12-31",
+			want: "1997-09-30 1997-10-01 1997-10-31 1997-11-01 1997-11-30 1997-12-01 1997-12-31 1998-01-01 1998-01-31 1998-02-01",
+		},
+		{
+			// Months without a 31st are skipped and not counted
+			name:  "monthly on the 31st for 5 occurrences",
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=31;COUNT=5",
+			start: "2026-01-31", to: "2027-12-31",
+			want: "2026-01-31 2026-03-31 2026-05-31 2026-07-31 2026-08-31",
+		},
+		{
+			name:  "monthly on the start day skips short months",
+			rule:  "FREQ=MONTHLY;COUNT=3",
+			start: "2026-01-30", to: "2027-12-31",
+			want: "2026-01-30 2026-03-30 2026-04-30",
+		},
+		{
+			name:  "every Friday the 13th",
+			rule:  "FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13",
+			start: "1997-09-02", to: "2000-12-31",
+			want: "1998-02-13 1998-03-13 1998-11-13 1999-08-13 2000-10-13",
+		},
+		{
+			name:  "yearly in June and July for 10 occurrences",
+			rule:  "FREQ=YEARLY;COUNT=10;BYMONTH=6,7",
+			start: "1997-06-10", to: "2005-12-31",
+			want: "1997-06-10 1997-07-10 1998-06-10 1998-07-10 1999-06-10 1999-07-10 2000-06-10 2000-07-10 2001-06-10 2001-07-10",
+		},
+		{
+			name:  "every 20th Monday of the year",
+			rule:  "FREQ=YEARLY;BYDAY=20MO",
+			start: "1997-05-19", to: "1999-12-31",
+			want: "1997-05-19 1998-05-18 1999-05-17",
+		},
+		{
+			name:  "every Thursday in March",
+			rule:  "FREQ=YEARLY;BYMONTH=3;BYDAY=TH",
+			start: "1997-03-13", to: "1998-12-31",
+			want: "1997-03-13 1997-03-20 1997-03-27 1998-03-05 1998-03-12 1998-03-19 1998-03-26",
+		},
+		{
+			name:  "COUNT counts from the start, not from the range",
+			rule:  "FREQ=DAILY;COUNT=5",
+			start: "2026-01-01", from: "2026-01-04", to: "2026-12-31",
+			want: "2026-01-04 2026-01-05",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := parseRRule(tt.rule)
+			if err != nil {
+				t.Fatalf("parseRRule(%q): %v", tt.rule, err)
+			}
+			from := tt.from
+			if from == "" {
+				from = tt.start
+			}
+
+			var got []string
+			for _, d := range rule.between(mustDate(t, tt.start), mustDate(t, from), mustDate(t, tt.to)) {
+				got = append(got, d.Format(dateLayout))
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("got  %s\nwant %s", strings.Join(got, " "), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRRuleRejects(t *testing.T) {
+	for _, rule := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=3;UNTIL=20260101",
+		"FREQ=DAILY;COUNT=3;COUNT=4",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=MONTHLY;BYDAY=0MO",
+		"FREQ=MONTHLY;BYDAY=XX",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=YEARLY;BYMONTH=13",
+		"FREQ=MONTHLY;BYSETPOS=-1;BYDAY=MO",
+		"FREQ=YEARLY;BYYEARDAY=100",
+		"FREQ=DAILY;BYHOUR=9",
+		"FREQ=WEEKLY;WKST=XX",
+		"FREQ=DAILY;UNTIL=2026",
+	} {
+		if _, err := parseRRule(rule); err == nil {
+			t.Errorf("parseRRule(%q) succeeded, want an error", rule)
+		}
+	}
+}
@@ -0,0 +1,646 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// A user's week schedule lists the routines planned on each weekday, in
+// day_routines.position order, for one Monday-to-Sunday week. A week
+// without its own schedule follows its template, the latest week before
+// it, and is only copied from the template the first time it is edited.
+// Editing a week therefore changes the weeks after it but never the ones
+// before. Edits bump the week's version like any other write. A week a
+// program enrollment covers shows the program instead and can't be edited.
+
+var weekDays = []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
+
+// parseWeekDay returns the canonical name of a weekday, ignoring case.
+func parseWeekDay(s string) (string, error) {
+	for _, day := range weekDays {
+		if strings.EqualFold(s, day) {
+			return day, nil
+		}
+	}
+	return "", fmt.Errorf("day must be a weekday name such as Monday, got %q", s)
+}
+
+// requestWeek returns the Monday starting the week a request is about:
+// ?week= (any day of the week), or the week of date.
+func requestWeek(r *http.Request, date string) (string, error) {
+	week := r.URL.Query().Get("week")
+	if week == "" {
+		week = date
+	}
+	day, err := time.Parse(dateLayout, week)
+	if err != nil {
+		return "", fmt.Errorf("invalid week %q, expected YYYY-MM-DD", week)
+	}
+	return weekStart(day).Format(dateLayout), nil
+}
+
+// findWeek returns the user's week schedule starting on start, or else
+// the template for that week. It returns sql.ErrNoRows if the user has no
+// schedule starting on or before start.
+func findWeek(q queryer, userID, start string) (id, weekStart string, err error) {
+	err = q.QueryRow(`
+		SELECT id, week_start::text FROM week_schedules
+		WHERE user_id = $1 AND week_start <= $2
+		ORDER BY week_start DESC
+		LIMIT 1`, userID, start).Scan(&id, &weekStart)
+	return id, weekStart, err
+}
+
+// copyWeek stores the user's schedule for the week starting on start as a
+// copy of templateID, or empty if templateID is empty. If the week was
+// stored concurrently, that week is returned unchanged.
+func copyWeek(q queryer, userID, start, templateID string) (string, error) {
+	var weekID string
+	err := q.QueryRow(`
+		INSERT INTO week_schedules (user_id, week_start)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id, week_start) DO NOTHING
+		RETURNING id`, userID, start).Scan(&weekID)
+	if err == sql.ErrNoRows {
+		err = q.QueryRow(`SELECT id FROM week_schedules WHERE user_id = $1 AND week_start = $2`, userID, start).
+			Scan(&weekID)
+		return weekID, err
+	}
+	if err != nil || templateID == "" {
+		return weekID, err
+	}
+
+	_, err = q.Exec(`
+		INSERT INTO day_schedules (week_id, day)
+		SELECT DISTINCT $1::uuid, day FROM day_schedules WHERE week_id = $2`, weekID, templateID)
+	if err != nil {
+		return "", err
+	}
+	_, err = q.Exec(`
+		INSERT INTO day_routines (day_id, routine_id, position)
+		SELECT nd.id, dr.routine_id, dr.position
+		FROM day_schedules od
+		JOIN day_routines dr ON dr.day_id = od.id
+		JOIN day_schedules nd ON nd.week_id = $1 AND nd.day = od.day
+		WHERE od.week_id = $2
+		ON CONFLICT DO NOTHING`, weekID, templateID)
+	if err != nil {
+		return "", err
+	}
+	return weekID, nil
+}
+
+// emptyWeek is a week of rest days for a user without a schedule.
+func emptyWeek(start string) *WeekSchedule {
+	week := &WeekSchedule{Schedule: []DaySchedule{}}
+	week.WeekStart, _ = time.Parse(dateLayout, start)
+	for _, day := range weekDays {
+		week.Schedule = append(week.Schedule, DaySchedule{Day: day, Routines: []Routine{}})
+	}
+	return week
+}
+
+// readWeek returns the schedule a user follows in the week starting on
+// start. A week that only follows its template is read from the template
+// without storing anything and has no id. identifier is the user's email
+// or UUID and date the day whose progress is filled in.
+func (db *DB) readWeek(userID, identifier, start, date string) (*WeekSchedule, error) {
+	weekID, weekStart, err := findWeek(db, userID, start)
+	if err == sql.ErrNoRows {
+		return emptyWeek(start), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	week, err := db.loadWeekSchedule(weekID, identifier, date)
+	if err != nil || weekStart == start {
+		return week, err
+	}
+	week.ID, week.Version = "", 0
+	week.WeekStart, _ = time.Parse(dateLayout, start)
+	for i := range week.Schedule {
+		week.Schedule[i].ID, week.Schedule[i].WeekID = "", ""
+	}
+	return week, nil
+}
+
+// plannedWeek returns the schedule a user follows in the week starting on
+// start: a program they are enrolled in wins over their own schedule.
+func (db *DB) plannedWeek(userID, identifier, start, date string, unit WeightUnit) (*WeekSchedule, error) {
+	week, err := db.programWeek(userID, identifier, start, date, unit)
+	if err == sql.ErrNoRows {
+		week, err = db.readWeek(userID, identifier, start, date)
+	}
+	return week, err
+}
+
+// resolveWeek returns what a user trains in the week starting on start: their
+// planned week with the routines their recurrences plan added on top.
+// Unknown users get seven rest days. unit is the one weights will be shown
+// in.
+func (db *DB) resolveWeek(identifier, start, date string, unit WeightUnit) (*WeekSchedule, error) {
+	week := emptyWeek(start)
+	if userID, err := db.resolveUserID(identifier); err == nil {
+		week, err = db.plannedWeek(userID, identifier, start, date, unit)
+		if err == nil {
+			err = db.addOccurrences(week, userID, identifier, start, date)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	first, err := time.Parse(dateLayout, start)
+	if err != nil {
+		return nil, err
+	}
+	for i := range week.Schedule {
+		week.Schedule[i].Date = first.AddDate(0, 0, i).Format(dateLayout)
+	}
+	return week, nil
+}
+
+// loadWeekSchedule loads a week schedule with every weekday, filling in the
+// user's progress for date (or today when date is empty). userID may be an
+// email or UUID.
+func (db *DB) loadWeekSchedule(weekID, userID, date string) (*WeekSchedule, error) {
+	week := WeekSchedule{ID: weekID, Schedule: []DaySchedule{}}
+	err := db.QueryRow(`
+		SELECT user_id, week_start, version, created_at, updated_at
+		FROM week_schedules WHERE id = $1`, weekID).
+		Scan(&week.UserID, &week.WeekStart, &week.Version, &week.CreatedAt, &week.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	dayIndex := make(map[string]int, len(weekDays))
+	for i, day := range weekDays {
+		week.Schedule = append(week.Schedule, DaySchedule{Day: day, WeekID: weekID, Routines: []Routine{}})
+		dayIndex[day] = i
+	}
+
+	rows, err := db.Query(`
+		SELECT ds.id, ds.day, ds.created_at, ds.updated_at, r.id, r.name, r.description,
+		       r.category, r.difficulty, r.duration_minutes, r.version
+		FROM day_schedules ds
+		LEFT JOIN day_routines dr ON dr.day_id = ds.id
+		LEFT JOIN routines r ON r.id = dr.routine_id AND r.retired_at IS NULL
+		WHERE ds.week_id = $1
+		ORDER BY dr.position`, weekID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ds DaySchedule
+		var rID, rName, rDesc sql.NullString
+		var rVersion sql.NullInt64
+		var meta routineMeta
+		err := rows.Scan(&ds.ID, &ds.Day, &ds.CreatedAt, &ds.UpdatedAt, &rID, &rName, &rDesc,
+			&meta.category, &meta.difficulty, &meta.duration, &rVersion)
+		if err != nil {
+			return nil, err
+		}
+		i, exists := dayIndex[ds.Day]
+		if !exists {
+			continue
+		}
+		day := &week.Schedule[i]
+		day.ID, day.CreatedAt, day.UpdatedAt = ds.ID, ds.CreatedAt, ds.UpdatedAt
+		if rID.Valid {
+			day.Routines = append(day.Routines, db.plannedRoutine(rID.String, rName.String, rDesc, meta, rVersion, userID, date))
+		}
+	}
+	return &week, rows.Err()
+}
+
+// plannedRoutine builds a routine planned on a schedule from its row, with
+// the user's progress for date.
+func (db *DB) plannedRoutine(id, name string, description sql.NullString, meta routineMeta, version sql.NullInt64, userID, date string) Routine {
+	routine := Routine{
+		ID:      id,
+		Name:    name,
+		Version: int(version.Int64),
+	}
+	if description.Valid {
+		routine.Description = &description.String
+	}
+	meta.apply(&routine)
+	routine.Workouts = db.getWorkoutsForRoutineWithProgress(id, userID, date)
+	return routine
+}
+
+// lockWeek locks the user's schedule for the week a request is about
+// (?week=, ?date= or the current week) for a change, storing it first if it
+// only followed its template or didn't exist. It writes a 409 if a program
+// enrollment covers the week, or with the current week if If-Match is stale.
+func (db *DB) lockWeek(w http.ResponseWriter, r *http.Request, q queryer, userID string, unit WeightUnit) (string, bool) {
+	date, err := db.requestDate(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	start, err := requestWeek(r, date)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	if program, err := enrolledProgram(q, userID, start); err == nil {
+		http.Error(w, fmt.Sprintf("The week of %s follows the program %q; end the enrollment to edit it", start, program),
+			http.StatusConflict)
+		return "", false
+	} else if err != sql.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+
+	weekID, weekStart, err := findWeek(q, userID, start)
+	if err == sql.ErrNoRows {
+		weekID, err = copyWeek(q, userID, start, "")
+	} else if err == nil && weekStart != start {
+		weekID, err = copyWeek(q, userID, start, weekID)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+
+	id, version, err := lockVersion(q, "week_schedules", weekID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	if !versionMatches(r, version) {
+		current, err := db.loadWeekSchedule(id, userID, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return "", false
+		}
+		current.inUnit(unit)
+		writeVersionConflict(w, version, current)
+		return "", false
+	}
+	return id, true
+}
+
+// touchWeek records a change to a week schedule's days.
+func touchWeek(q queryer, weekID string) error {
+	_, err := q.Exec(`
+		UPDATE week_schedules SET version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, weekID)
+	return err
+}
+
+// scheduleDayID returns the id of a week's day, adding the day if the week
+// doesn't have it yet.
+func scheduleDayID(q queryer, weekID, day string) (string, error) {
+	var dayID string
+	err := q.QueryRow(`SELECT id FROM day_schedules WHERE week_id = $1 AND day = $2 LIMIT 1`, weekID, day).
+		Scan(&dayID)
+	if err == sql.ErrNoRows {
+		err = q.QueryRow(`
+			INSERT INTO day_schedules (week_id, day)
+			VALUES ($1, $2)
+			RETURNING id`, weekID, day).Scan(&dayID)
+	}
+	return dayID, err
+}
+
+// dayRoutineIDs returns the routines planned on a day, in order.
+func dayRoutineIDs(q queryer, dayID string) ([]string, error) {
+	rows, err := q.Query(`
+		SELECT dr.routine_id FROM day_routines dr
+		JOIN routines r ON r.id = dr.routine_id AND r.retired_at IS NULL
+		WHERE dr.day_id = $1
+		ORDER BY dr.position`, dayID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// setDayRoutines replaces the routines planned on a day, numbering their
+// positions from zero.
+func setDayRoutines(q queryer, dayID string, routineIDs []string) error {
+	if _, err := q.Exec(`DELETE FROM day_routines WHERE day_id = $1`, dayID); err != nil {
+		return err
+	}
+	for pos, id := range routineIDs {
+		_, err := q.Exec(`
+			INSERT INTO day_routines (day_id, routine_id, position)
+			VALUES ($1, $2, $3)`, dayID, id, pos)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := q.Exec(`UPDATE day_schedules SET updated_at = CURRENT_TIMESTAMP WHERE id = $1`, dayID)
+	return err
+}
+
+// checkDayRoutines checks that routineIDs name existing routines, each once.
+func checkDayRoutines(q queryer, day string, routineIDs []string) error {
+	seen := make(map[string]bool, len(routineIDs))
+	for _, id := range routineIDs {
+		if seen[id] {
+			return fmt.Errorf("routine %s is listed twice on %s", id, day)
+		}
+		seen[id] = true
+	}
+	return routinesExist(q, routineIDs)
+}
+
+// routinesExist checks that every id in routineIDs names a routine.
+func routinesExist(q queryer, routineIDs []string) error {
+	if len(routineIDs) == 0 {
+		return nil
+	}
+
+	rows, err := q.Query(`SELECT id::text FROM routines WHERE id::text = ANY($1) AND retired_at IS NULL`, pq.Array(routineIDs))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	found := make(map[string]bool, len(routineIDs))
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		found[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range routineIDs {
+		if !found[id] {
+			return fmt.Errorf("routine %s not found", id)
+		}
+	}
+	return nil
+}
+
+// scheduleRequest resolves the user and weight unit of a week schedule
+// edit from ?user_id= and ?unit=.
+func (db *DB) scheduleRequest(w http.ResponseWriter, r *http.Request) (string, WeightUnit, bool) {
+	userID, ok := db.requireUserID(w, r.URL.Query().Get("user_id"))
+	if !ok {
+		return "", "", false
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return "", "", false
+	}
+	return userID, unit, true
+}
+
+// editDay applies edit to the routines planned on a day of the user's
+// current week and responds with the updated week. edit returns the new
+// list, or writes an error response and returns false.
+func (db *DB) editDay(w http.ResponseWriter, r *http.Request, edit func(day string, routineIDs []string) ([]string, bool)) {
+	userID, unit, ok := db.scheduleRequest(w, r)
+	if !ok {
+		return
+	}
+	day, err := parseWeekDay(mux.Vars(r)["day"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	weekID, ok := db.lockWeek(w, r, tx, userID, unit)
+	if !ok {
+		return
+	}
+	dayID, err := scheduleDayID(tx, weekID, day)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	current, err := dayRoutineIDs(tx, dayID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	routineIDs, ok := edit(day, current)
+	if !ok {
+		return
+	}
+	if err := checkDayRoutines(tx, day, routineIDs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := setDayRoutines(tx, dayID, routineIDs); err != nil {
+		log.Printf("Error updating day schedule: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := touchWeek(tx, weekID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	db.writeWeek(w, weekID, userID, unit)
+}
+
+// writeWeek responds with a stored week schedule as GET /week-schedule
+// shows it, with its recurring routines and dates, and its ETag.
+func (db *DB) writeWeek(w http.ResponseWriter, weekID, userID string, unit WeightUnit) {
+	var start string
+	err := db.QueryRow(`SELECT week_start::text FROM week_schedules WHERE id = $1`, weekID).Scan(&start)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	week, err := db.resolveWeek(userID, start, "", unit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	week.inUnit(unit)
+	setETag(w, week.Version)
+	writeJSON(w, http.StatusOK, week)
+}
+
+// AddScheduledRoutine plans a routine on a day, at position (from zero) or
+// after the day's other routines.
+func (db *DB) AddScheduledRoutine(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		RoutineID string `json:"routine_id"`
+		Position  *int   `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if in.RoutineID == "" {
+		http.Error(w, "routine_id is required", http.StatusBadRequest)
+		return
+	}
+	if in.Position != nil && *in.Position < 0 {
+		http.Error(w, "position must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	db.editDay(w, r, func(day string, routineIDs []string) ([]string, bool) {
+		for _, id := range routineIDs {
+			if id == in.RoutineID {
+				http.Error(w, fmt.Sprintf("Routine is already scheduled on %s", day), http.StatusConflict)
+				return nil, false
+			}
+		}
+		pos := len(routineIDs)
+		if in.Position != nil && *in.Position < pos {
+			pos = *in.Position
+		}
+		routineIDs = append(routineIDs, "")
+		copy(routineIDs[pos+1:], routineIDs[pos:])
+		routineIDs[pos] = in.RoutineID
+		return routineIDs, true
+	})
+}
+
+// RemoveScheduledRoutine takes a routine off a day.
+func (db *DB) RemoveScheduledRoutine(w http.ResponseWriter, r *http.Request) {
+	routineID := mux.Vars(r)["routineId"]
+	db.editDay(w, r, func(day string, routineIDs []string) ([]string, bool) {
+		for i, id := range routineIDs {
+			if id == routineID {
+				return append(routineIDs[:i], routineIDs[i+1:]...), true
+			}
+		}
+		http.Error(w, fmt.Sprintf("Routine is not scheduled on %s", day), http.StatusNotFound)
+		return nil, false
+	})
+}
+
+// ReorderScheduledRoutines sets the order of a day's routines. The body
+// must list every routine planned on the day exactly once.
+func (db *DB) ReorderScheduledRoutines(w http.ResponseWriter, r *http.Request) {
+	var order struct {
+		RoutineIDs []string `json:"routine_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db.editDay(w, r, func(day string, routineIDs []string) ([]string, bool) {
+		existing := make(map[string]bool, len(routineIDs))
+		for _, id := range routineIDs {
+			existing[id] = true
+		}
+		if len(order.RoutineIDs) != len(existing) {
+			http.Error(w, "routine_ids must list every routine on the day exactly once", http.StatusBadRequest)
+			return nil, false
+		}
+		seen := make(map[string]bool, len(order.RoutineIDs))
+		for _, id := range order.RoutineIDs {
+			if !existing[id] || seen[id] {
+				http.Error(w, "routine_ids must list every routine on the day exactly once", http.StatusBadRequest)
+				return nil, false
+			}
+			seen[id] = true
+		}
+		return order.RoutineIDs, true
+	})
+}
+
+// ReplaceWeekSchedule replaces every day of the user's current week in one
+// transaction. The body maps day names to routine ids in order; days left
+// out become rest days.
+func (db *DB) ReplaceWeekSchedule(w http.ResponseWriter, r *http.Request) {
+	userID, unit, ok := db.scheduleRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var in struct {
+		Schedule map[string][]string `json:"schedule"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if in.Schedule == nil {
+		http.Error(w, "schedule is required", http.StatusBadRequest)
+		return
+	}
+	schedule := make(map[string][]string, len(in.Schedule))
+	for name, routineIDs := range in.Schedule {
+		day, err := parseWeekDay(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, dup := schedule[day]; dup {
+			http.Error(w, fmt.Sprintf("%s is listed twice", day), http.StatusBadRequest)
+			return
+		}
+		if err := checkDayRoutines(db, day, routineIDs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		schedule[day] = routineIDs
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	weekID, ok := db.lockWeek(w, r, tx, userID, unit)
+	if !ok {
+		return
+	}
+	for _, day := range weekDays {
+		dayID, err := scheduleDayID(tx, weekID, day)
+		if err == nil {
+			err = setDayRoutines(tx, dayID, schedule[day])
+		}
+		if err != nil {
+			log.Printf("Error replacing week schedule: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := touchWeek(tx, weekID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	db.writeWeek(w, weekID, userID, unit)
+}
@@ -37,184 +37,163 @@ func (db *DB) SeedData() error {
 	}
 	
 	// Create routines and workouts
-	routines := []struct {
-		Name        string
-		Description string
-		Workouts    []struct {
-			Name         string
-			Type         *string
-			ExerciseType string
-			Weight       *float64
-			Time         *int
-			Reps         *int
-			Sets         *int
-			Description  *string
-		}
-	}{
+	routines := []RoutineInput{
 		{
-			Name:        "Upper Body Power",
-			Description: "Intense upper body workout focusing on strength",
-			Workouts: []struct {
-				Name         string
-				Type         *string
-				ExerciseType string
-				Weight       *float64
-				Time         *int
-				Reps         *int
-				Sets         *int
-				Description  *string
-			}{
+			Name:            "Upper Body Power",
+			Description:     stringPtr("Intense upper body workout focusing on strength"),
+			Category:        stringPtr("strength"),
+			Difficulty:      stringPtr("intermediate"),
+			DurationMinutes: intPtr(45),
+			Workouts: []WorkoutInput{
 				{
 					Name:         "Bench Press",
-					Type:         stringPtr("upper_body"),
-					ExerciseType: "lift",
+					Type:         workoutTypePtr(UpperBody),
+					ExerciseType: Lift,
 					Weight:       float64Ptr(135),
 					Reps:         intPtr(8),
 					Sets:         intPtr(4),
 					Description:  stringPtr("Flat bench with barbell"),
+					Instructions: stringPtr("Lower bar to chest, press up explosively"),
+					MuscleGroups: []string{"chest", "triceps", "shoulders"},
 				},
 				{
 					Name:         "Pull-ups",
-					Type:         stringPtr("upper_body"),
-					ExerciseType: "lift",
+					Type:         workoutTypePtr(UpperBody),
+					ExerciseType: Lift,
 					Reps:         intPtr(10),
 					Sets:         intPtr(3),
 					Description:  stringPtr("Wide grip pull-ups"),
+					Instructions: stringPtr("Hang from bar, pull up until chin over bar"),
+					MuscleGroups: []string{"back", "biceps"},
 				},
 				{
 					Name:         "Shoulder Press",
-					Type:         stringPtr("upper_body"),
-					ExerciseType: "lift",
+					Type:         workoutTypePtr(UpperBody),
+					ExerciseType: Lift,
 					Weight:       float64Ptr(95),
 					Reps:         intPtr(10),
 					Sets:         intPtr(3),
 					Description:  stringPtr("Overhead press with barbell"),
+					Instructions: stringPtr("Press barbell from shoulders to overhead"),
+					MuscleGroups: []string{"shoulders", "triceps"},
 				},
 			},
 		},
 		{
-			Name:        "Leg Day",
-			Description: "Complete lower body workout",
-			Workouts: []struct {
-				Name         string
-				Type         *string
-				ExerciseType string
-				Weight       *float64
-				Time         *int
-				Reps         *int
-				Sets         *int
-				Description  *string
-			}{
+			Name:            "Leg Day",
+			Description:     stringPtr("Complete lower body workout"),
+			Category:        stringPtr("strength"),
+			Difficulty:      stringPtr("intermediate"),
+			DurationMinutes: intPtr(60),
+			Workouts: []WorkoutInput{
 				{
 					Name:         "Squats",
-					Type:         stringPtr("lower_body"),
-					ExerciseType: "lift",
+					Type:         workoutTypePtr(LowerBody),
+					ExerciseType: Lift,
 					Weight:       float64Ptr(225),
 					Reps:         intPtr(8),
 					Sets:         intPtr(4),
 					Description:  stringPtr("Back squats with proper depth"),
+					Instructions: stringPtr("Squat down until thighs parallel to floor"),
+					MuscleGroups: []string{"quadriceps", "glutes", "hamstrings"},
 				},
 				{
 					Name:         "Romanian Deadlifts",
-					Type:         stringPtr("lower_body"),
-					ExerciseType: "lift",
+					Type:         workoutTypePtr(LowerBody),
+					ExerciseType: Lift,
 					Weight:       float64Ptr(185),
 					Reps:         intPtr(10),
 					Sets:         intPtr(3),
 					Description:  stringPtr("Focus on hamstring stretch"),
+					Instructions: stringPtr("Hinge at hips, lower bar while keeping legs straight"),
+					MuscleGroups: []string{"hamstrings", "glutes", "lower_back"},
 				},
 				{
 					Name:         "Leg Press",
-					Type:         stringPtr("lower_body"),
-					ExerciseType: "lift",
+					Type:         workoutTypePtr(LowerBody),
+					ExerciseType: Lift,
 					Weight:       float64Ptr(360),
 					Reps:         intPtr(12),
 					Sets:         intPtr(3),
 					Description:  stringPtr("Full range of motion"),
+					Instructions: stringPtr("Press weight with legs, full extension"),
+					MuscleGroups: []string{"quadriceps", "glutes"},
 				},
 			},
 		},
 		{
-			Name:        "Core Circuit",
-			Description: "Abs and core strengthening",
-			Workouts: []struct {
-				Name         string
-				Type         *string
-				ExerciseType string
-				Weight       *float64
-				Time         *int
-				Reps         *int
-				Sets         *int
-				Description  *string
-			}{
+			Name:            "Core Circuit",
+			Description:     stringPtr("Abs and core strengthening"),
+			Category:        stringPtr("core"),
+			Difficulty:      stringPtr("beginner"),
+			DurationMinutes: intPtr(30),
+			Workouts: []WorkoutInput{
 				{
 					Name:         "Plank",
-					Type:         stringPtr("abs"),
-					ExerciseType: "timed",
+					Type:         workoutTypePtr(Abs),
+					ExerciseType: Timed,
 					Time:         intPtr(60),
 					Sets:         intPtr(3),
 					Description:  stringPtr("Hold plank position"),
+					Instructions: stringPtr("Hold straight body position on forearms"),
+					MuscleGroups: []string{"core", "shoulders"},
 				},
 				{
 					Name:         "Russian Twists",
-					Type:         stringPtr("abs"),
-					ExerciseType: "lift",
+					Type:         workoutTypePtr(Abs),
+					ExerciseType: Lift,
 					Weight:       float64Ptr(25),
 					Reps:         intPtr(20),
 					Sets:         intPtr(3),
 					Description:  stringPtr("With medicine ball"),
+					Instructions: stringPtr("Sit with knees bent, rotate torso side to side"),
+					MuscleGroups: []string{"obliques", "core"},
 				},
 				{
 					Name:         "Leg Raises",
-					Type:         stringPtr("abs"),
-					ExerciseType: "lift",
+					Type:         workoutTypePtr(Abs),
+					ExerciseType: Lift,
 					Reps:         intPtr(15),
 					Sets:         intPtr(3),
 					Description:  stringPtr("Hanging leg raises"),
+					Instructions: stringPtr("Hang from bar, raise legs to horizontal"),
+					MuscleGroups: []string{"lower_abs", "hip_flexors"},
 				},
 			},
 		},
 		{
-			Name:        "Basketball Practice",
-			Description: "Weekly basketball session",
-			Workouts: []struct {
-				Name         string
-				Type         *string
-				ExerciseType string
-				Weight       *float64
-				Time         *int
-				Reps         *int
-				Sets         *int
-				Description  *string
-			}{
+			Name:            "Basketball Practice",
+			Description:     stringPtr("Weekly basketball session"),
+			Category:        stringPtr("activity"),
+			Difficulty:      stringPtr("moderate"),
+			DurationMinutes: intPtr(90),
+			Workouts: []WorkoutInput{
 				{
 					Name:         "Basketball",
 					Type:         nil,
-					ExerciseType: "activity",
+					ExerciseType: Activity,
 					Time:         intPtr(90),
 					Description:  stringPtr("Full court games and drills"),
+					Instructions: stringPtr("Mix of shooting drills, scrimmage, and conditioning"),
+					MuscleGroups: []string{"full_body", "cardio"},
 				},
 			},
 		},
 		{
-			Name:        "Yoga Class",
-			Description: "Flexibility and mindfulness",
-			Workouts: []struct {
-				Name         string
-				Type         *string
-				ExerciseType string
-				Weight       *float64
-				Time         *int
-				Reps         *int
-				Sets         *int
-				Description  *string
-			}{
+			Name:            "Yoga Class",
+			Description:     stringPtr("Flexibility and mindfulness"),
+			Category:        stringPtr("flexibility"),
+			Difficulty:      stringPtr("beginner"),
+			DurationMinutes: intPtr(60),
+			Workouts: []WorkoutInput{
 				{
 					Name:         "Vinyasa Yoga",
 					Type:         nil,
-					ExerciseType: "class",
+					ExerciseType: Class,
 					Time:         intPtr(60),
 					Description:  stringPtr("Flow yoga class"),
+					Instructions: stringPtr("Follow instructor through flowing sequences"),
+					MuscleGroups: []string{"full_body", "flexibility"},
 				},
 			},
 		},
@@ -224,36 +203,26 @@ func (db *DB) SeedData() error {
 	
 	// Insert routines and workouts
 	for _, routine := range routines {
-		var routineID string
-		err = db.QueryRow(`
-			INSERT INTO routines (name, description) 
-			VALUES ($1, $2) 
-			RETURNING id`, 
-			routine.Name, routine.Description).Scan(&routineID)
+		routineID, err := insertRoutine(db, routine)
 		if err != nil {
 			return err
 		}
 		
 		routineIDs = append(routineIDs, routineID)
 		
-		// Insert workouts for this routine
+		// Insert workouts for this routine in order
 		for _, workout := range routine.Workouts {
-			_, err = db.Exec(`
-				INSERT INTO workouts (routine_id, name, type, exercise_type, weight, time, reps, sets, description)
-				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
-				routineID, workout.Name, workout.Type, workout.ExerciseType,
-				workout.Weight, workout.Time, workout.Reps, workout.Sets, workout.Description)
-			if err != nil {
+			if _, err := insertWorkout(db, routineID, workout); err != nil {
 				return err
 			}
 		}
 	}
 	
-	// Create week schedule
+	// Create week schedule for this week; later weeks are copied from it
 	var weekID string
 	err = db.QueryRow(`
 		INSERT INTO week_schedules (user_id, week_start) 
-		VALUES ($1, CURRENT_DATE - EXTRACT(DOW FROM CURRENT_DATE)::INTEGER) 
+		VALUES ($1, date_trunc('week', CURRENT_DATE)::date) 
 		RETURNING id`, userID).Scan(&weekID)
 	if err != nil {
 		return err
@@ -311,4 +280,8 @@ func float64Ptr(f float64) *float64 {
 
 func intPtr(i int) *int {
 	return &i
+}
+
+func workoutTypePtr(t WorkoutType) *WorkoutType {
+	return &t
 }
\ No newline at end of file
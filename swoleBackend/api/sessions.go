@@ -0,0 +1,340 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var errUnknownSession = errors.New("session not found for this user")
+
+const sessionQuery = `
+	SELECT s.id, s.user_id, s.routine_id, r.name, s.started_at, s.ended_at,
+	       EXTRACT(EPOCH FROM (s.ended_at - s.started_at))::INTEGER, s.notes,
+	       (SELECT COUNT(*) FROM user_progress up WHERE up.session_id = s.id),
+	       s.created_at, s.updated_at
+	FROM workout_sessions s
+	LEFT JOIN routines r ON r.id = s.routine_id`
+
+func scanSession(row interface{ Scan(...interface{}) error }) (*WorkoutSession, error) {
+	var s WorkoutSession
+	var routineID, routineName, notes sql.NullString
+	var endedAt sql.NullTime
+	var duration sql.NullInt64
+
+	err := row.Scan(&s.ID, &s.UserID, &routineID, &routineName, &s.StartedAt, &endedAt,
+		&duration, &notes, &s.ProgressCount, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if routineID.Valid {
+		s.RoutineID = &routineID.String
+	}
+	if routineName.Valid {
+		s.RoutineName = &routineName.String
+	}
+	if endedAt.Valid {
+		s.EndedAt = &endedAt.Time
+	}
+	if duration.Valid {
+		d := int(duration.Int64)
+		s.DurationSeconds = &d
+	}
+	if notes.Valid {
+		s.Notes = &notes.String
+	}
+	return &s, nil
+}
+
+// loadSession returns a session with the progress logged during it.
+func (db *DB) loadSession(sessionID string) (*WorkoutSession, error) {
+	session, err := scanSession(db.QueryRow(sessionQuery+` WHERE s.id::text = $1`, sessionID))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT `+progressColumns+`
+		FROM user_progress up
+		WHERE up.session_id = $1
+		ORDER BY up.created_at`, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	session.Progress = []UserProgress{}
+	for rows.Next() {
+		p, err := scanUserProgress(rows)
+		if err != nil {
+			return nil, err
+		}
+		session.Progress = append(session.Progress, *p)
+	}
+	return session, rows.Err()
+}
+
+// sessionForLog picks the session a new log entry belongs to: the one the
+// client named, or else the user's open session if there is one. Backdated
+// entries are only attached to a session the client names.
+func sessionForLog(q queryer, userID, requested string, backdated bool) (*string, error) {
+	var sessionID string
+	if requested != "" {
+		err := q.QueryRow(`SELECT id FROM workout_sessions WHERE id::text = $1 AND user_id = $2`, requested, userID).
+			Scan(&sessionID)
+		if err == sql.ErrNoRows {
+			return nil, errUnknownSession
+		}
+		return &sessionID, err
+	}
+	if backdated {
+		return nil, nil
+	}
+
+	err := q.QueryRow(`
+		SELECT id FROM workout_sessions
+		WHERE user_id = $1 AND ended_at IS NULL
+		ORDER BY started_at DESC LIMIT 1`, userID).Scan(&sessionID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &sessionID, err
+}
+
+// writeOpenSession responds 409 with the user's open session and returns
+// true if they have one. It writes a 500 and returns true if the lookup
+// fails.
+func (db *DB) writeOpenSession(w http.ResponseWriter, userID string, unit WeightUnit) bool {
+	open, err := scanSession(db.QueryRow(sessionQuery+`
+		WHERE s.user_id = $1 AND s.ended_at IS NULL
+		ORDER BY s.started_at DESC LIMIT 1`, userID))
+	if err == sql.ErrNoRows {
+		return false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	open.inUnit(unit)
+	writeJSON(w, http.StatusConflict, open)
+	return true
+}
+
+// StartSession opens a new session. A user can only have one open session
+// at a time; starting another returns 409 with the open one.
+func (db *DB) StartSession(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		UserID    string     `json:"user_id"`
+		RoutineID *string    `json:"routine_id"`
+		StartedAt *time.Time `json:"started_at"`
+		Notes     *string    `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := db.requireUserID(w, body.UserID)
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if body.RoutineID != nil {
+		if _, err := db.loadRoutine(*body.RoutineID); err == sql.ErrNoRows {
+			http.Error(w, "Routine not found", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if db.writeOpenSession(w, userID, unit) {
+		return
+	}
+
+	var sessionID string
+	err := db.QueryRow(`
+		INSERT INTO workout_sessions (user_id, routine_id, started_at, notes)
+		VALUES ($1, $2, COALESCE($3, CURRENT_TIMESTAMP), $4)
+		RETURNING id`, userID, body.RoutineID, body.StartedAt, body.Notes).Scan(&sessionID)
+	if isUniqueViolation(err) && db.writeOpenSession(w, userID, unit) {
+		// Another session was started since the check above
+		return
+	} else if err != nil {
+		log.Printf("Error starting session: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	session, err := db.loadSession(sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	session.inUnit(unit)
+	writeJSON(w, http.StatusCreated, session)
+}
+
+// UpdateSession finishes a session and/or edits its notes for its owner,
+// the body's user_id. Sending "finish": true ends the session now unless
+// ended_at is given. Finishing an open session moves the user's rotation
+// past its routine.
+func (db *DB) UpdateSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["id"]
+
+	current, err := db.loadSession(sessionID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	unit, ok := db.requireUnit(w, r, current.UserID)
+	if !ok {
+		return
+	}
+
+	body := struct {
+		UserID  string     `json:"user_id"`
+		Finish  bool       `json:"finish"`
+		EndedAt *time.Time `json:"ended_at"`
+		Notes   *string    `json:"notes"`
+	}{EndedAt: current.EndedAt, Notes: current.Notes}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	userID, ok := db.requireUserID(w, body.UserID)
+	if !ok {
+		return
+	}
+	if userID != current.UserID {
+		http.Error(w, "Session belongs to another user", http.StatusForbidden)
+		return
+	}
+
+	if body.Finish && body.EndedAt == nil {
+		now := time.Now()
+		body.EndedAt = &now
+	}
+	if body.EndedAt != nil && body.EndedAt.Before(current.StartedAt) {
+		http.Error(w, "ended_at must not be before started_at", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var open bool
+	err = tx.QueryRow(`SELECT ended_at IS NULL FROM workout_sessions WHERE id = $1 FOR UPDATE`, current.ID).
+		Scan(&open)
+	if err == nil {
+		_, err = tx.Exec(`
+			UPDATE workout_sessions
+			SET ended_at = $2, notes = $3, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1`, current.ID, body.EndedAt, body.Notes)
+	}
+	if err == nil && open && body.EndedAt != nil && current.RoutineID != nil {
+		err = advanceRotation(tx, current.UserID, *current.RoutineID)
+	}
+	if err != nil {
+		log.Printf("Error updating session: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	session, err := db.loadSession(current.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	session.inUnit(unit)
+	writeJSON(w, http.StatusOK, session)
+}
+
+func (db *DB) GetSession(w http.ResponseWriter, r *http.Request) {
+	session, err := db.loadSession(mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	unit, ok := db.requireUnit(w, r, session.UserID)
+	if !ok {
+		return
+	}
+	session.inUnit(unit)
+	writeJSON(w, http.StatusOK, session)
+}
+
+// GetSessions lists a user's sessions, newest first. Optional from/to
+// (YYYY-MM-DD, inclusive) bound the start date in the user's timezone;
+// limit defaults to 20.
+func (db *DB) GetSessions(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	userID, ok := db.requireUserID(w, params.Get("user_id"))
+	if !ok {
+		return
+	}
+
+	limit := 20
+	if l := params.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 100 {
+			http.Error(w, "limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	loc, err := db.userLocation(userID, params.Get("timezone"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(sessionQuery+`
+		WHERE s.user_id = $1
+		  AND ($2 = '' OR (s.started_at AT TIME ZONE $5)::date >= NULLIF($2, '')::date)
+		  AND ($3 = '' OR (s.started_at AT TIME ZONE $5)::date <= NULLIF($3, '')::date)
+		ORDER BY s.started_at DESC
+		LIMIT $4`, userID, params.Get("from"), params.Get("to"), limit, loc.String())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer rows.Close()
+
+	sessions := []WorkoutSession{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			continue
+		}
+		sessions = append(sessions, *s)
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
@@ -0,0 +1,338 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func (in *WorkoutSetInput) validate() error {
+	if in.SetNumber != nil && *in.SetNumber < 1 {
+		return errors.New("setNumber must be at least 1")
+	}
+	if in.Reps != nil && *in.Reps < 0 {
+		return errors.New("reps must not be negative")
+	}
+	if in.Weight != nil && *in.Weight < 0 {
+		return errors.New("weight must not be negative")
+	}
+	if in.Duration != nil && *in.Duration < 0 {
+		return errors.New("duration must not be negative")
+	}
+	if in.RPE != nil && (*in.RPE < 1 || *in.RPE > 10) {
+		return errors.New("rpe must be between 1 and 10")
+	}
+	if in.Reps == nil && in.Weight == nil && in.Duration == nil {
+		return errors.New("a set needs at least one of reps, weight or duration")
+	}
+	return nil
+}
+
+// workoutExists reports whether workoutID names an active workout.
+func (db *DB) workoutExists(workoutID string) (bool, error) {
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM workouts WHERE id::text = $1 AND retired_at IS NULL)`, workoutID).
+		Scan(&exists)
+	return exists, err
+}
+
+// syncProgressFromSets recomputes the user_progress summary for a day from
+// its logged sets so that clients reading userWeight/userTime keep working.
+// The heaviest weight and longest duration of the working sets are used,
+// falling back to warm-up sets when nothing else was logged. The summary
+// row is removed once the day has no sets left.
+func syncProgressFromSets(q queryer, userID, workoutID, date string, sessionID *string) error {
+	_, err := q.Exec(`
+		INSERT INTO user_progress (user_id, workout_id, weight, time, session_id, date)
+		SELECT $1::uuid, $2::uuid,
+		       COALESCE(MAX(weight) FILTER (WHERE NOT is_warmup), MAX(weight)),
+		       COALESCE(MAX(duration) FILTER (WHERE NOT is_warmup), MAX(duration)),
+		       $4::uuid, $3::date
+		FROM workout_sets
+		WHERE user_id = $1::uuid AND workout_id = $2::uuid AND date = $3::date
+		HAVING COUNT(*) > 0
+		ON CONFLICT (user_id, workout_id, date)
+		DO UPDATE SET weight = EXCLUDED.weight, time = EXCLUDED.time,
+		              session_id = COALESCE(EXCLUDED.session_id, user_progress.session_id),
+		              version = user_progress.version + 1, updated_at = CURRENT_TIMESTAMP`,
+		userID, workoutID, date, sessionID)
+	if err != nil {
+		return err
+	}
+
+	// Record the removal so clients syncing changes drop the entry too
+	removed, err := scanUserProgress(q.QueryRow(`
+		DELETE FROM user_progress up
+		WHERE up.user_id = $1 AND up.workout_id = $2 AND up.date = $3
+		  AND NOT EXISTS (
+		      SELECT 1 FROM workout_sets ws
+		      WHERE ws.user_id = up.user_id AND ws.workout_id = up.workout_id AND ws.date = up.date
+		  )
+		RETURNING `+progressColumns, userID, workoutID, date))
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return auditProgress(q, ProgressDeleted, &ProgressSnapshot{Progress: *removed})
+}
+
+func loadSetLog(q queryer, userID, workoutID, date string) (*WorkoutSetLog, error) {
+	setLog := &WorkoutSetLog{
+		WorkoutID: workoutID,
+		Date:      date,
+		Sets:      []WorkoutSet{},
+	}
+
+	rows, err := q.Query(`
+		SELECT id, user_id, workout_id, date::text, set_number, reps, weight, duration, rpe, is_warmup, created_at
+		FROM workout_sets
+		WHERE user_id = $1 AND workout_id = $2 AND date = $3
+		ORDER BY set_number`, userID, workoutID, date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var set WorkoutSet
+		var reps, duration sql.NullInt64
+		var weight, rpe sql.NullFloat64
+
+		err := rows.Scan(&set.ID, &set.UserID, &set.WorkoutID, &set.Date, &set.SetNumber,
+			&reps, &weight, &duration, &rpe, &set.Warmup, &set.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if reps.Valid {
+			v := int(reps.Int64)
+			set.Reps = &v
+		}
+		if weight.Valid {
+			set.Weight = &weight.Float64
+		}
+		if duration.Valid {
+			v := int(duration.Int64)
+			set.Duration = &v
+		}
+		if rpe.Valid {
+			set.RPE = &rpe.Float64
+		}
+		setLog.Sets = append(setLog.Sets, set)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var weight sql.NullFloat64
+	var t sql.NullInt64
+	err = q.QueryRow(`
+		SELECT weight, time FROM user_progress
+		WHERE user_id = $1 AND workout_id = $2 AND date = $3`, userID, workoutID, date).Scan(&weight, &t)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if weight.Valid {
+		setLog.UserWeight = &weight.Float64
+	}
+	if t.Valid {
+		v := int(t.Int64)
+		setLog.UserTime = &v
+	}
+
+	return setLog, nil
+}
+
+// LogWorkoutSets records one or more sets for the user's current day, or
+// for an earlier day given as date or logged_at.
+func (db *DB) LogWorkoutSets(w http.ResponseWriter, r *http.Request) {
+	workoutID := mux.Vars(r)["id"]
+
+	var body struct {
+		UserID    string            `json:"user_id"`
+		SessionID string            `json:"session_id"`
+		Date      string            `json:"date"`
+		LoggedAt  *time.Time        `json:"logged_at"`
+		Timezone  string            `json:"timezone"`
+		Sets      []WorkoutSetInput `json:"sets"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body.Sets) == 0 {
+		http.Error(w, "At least one set is required", http.StatusBadRequest)
+		return
+	}
+	for i := range body.Sets {
+		if err := body.Sets[i].validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	userID, ok := db.requireUserID(w, body.UserID)
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+	for i := range body.Sets {
+		body.Sets[i].Weight = unit.pounds(body.Sets[i].Weight)
+	}
+	if exists, err := db.workoutExists(workoutID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if !exists {
+		http.Error(w, "Workout not found", http.StatusNotFound)
+		return
+	}
+
+	sessionID, err := sessionForLog(db, userID, body.SessionID, body.Date != "" || body.LoggedAt != nil)
+	if err == errUnknownSession {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	date, ok := db.requireLogDate(w, userID, body.Timezone, body.Date, body.LoggedAt)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, set := range body.Sets {
+		_, err := tx.Exec(`
+			INSERT INTO workout_sets (user_id, workout_id, date, set_number, reps, weight, duration, rpe, is_warmup,
+			                          session_id)
+			VALUES ($1, $2, $3,
+				COALESCE($4, (SELECT COALESCE(MAX(set_number), 0) + 1 FROM workout_sets
+				              WHERE user_id = $1 AND workout_id = $2 AND date = $3)),
+				$5, $6, $7, $8, $9, $10)
+			ON CONFLICT (user_id, workout_id, date, set_number)
+			DO UPDATE SET reps = EXCLUDED.reps, weight = EXCLUDED.weight, duration = EXCLUDED.duration,
+			              rpe = EXCLUDED.rpe, is_warmup = EXCLUDED.is_warmup, session_id = EXCLUDED.session_id`,
+			userID, workoutID, date, set.SetNumber, set.Reps, set.Weight, set.Duration, set.RPE, set.Warmup,
+			sessionID)
+		if err != nil {
+			log.Printf("Error logging set: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := syncProgressFromSets(tx, userID, workoutID, date, sessionID); err != nil {
+		log.Printf("Error updating progress from sets: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	records, err := detectRecords(tx, userID, workoutID, date)
+	if err != nil {
+		log.Printf("Error detecting records: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setLog, err := loadSetLog(db, userID, workoutID, date)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setLog.Records = records
+	setLog.inUnit(unit)
+	writeJSON(w, http.StatusCreated, setLog)
+}
+
+// GetWorkoutSets returns the sets logged for a workout on a day (the
+// user's today by default, or ?date=YYYY-MM-DD).
+func (db *DB) GetWorkoutSets(w http.ResponseWriter, r *http.Request) {
+	workoutID := mux.Vars(r)["id"]
+
+	userID, ok := db.requireUserID(w, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+	unit, ok := db.requireUnit(w, r, userID)
+	if !ok {
+		return
+	}
+
+	date, err := db.requestDate(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	setLog, err := loadSetLog(db, userID, workoutID, date)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	setLog.inUnit(unit)
+	writeJSON(w, http.StatusOK, setLog)
+}
+
+func (db *DB) DeleteWorkoutSet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	userID, ok := db.requireUserID(w, r.URL.Query().Get("user_id"))
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var date string
+	err = tx.QueryRow(`
+		DELETE FROM workout_sets
+		WHERE id::text = $1 AND workout_id::text = $2 AND user_id = $3
+		RETURNING date::text`, vars["setId"], vars["id"], userID).Scan(&date)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Set not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := syncProgressFromSets(tx, userID, vars["id"], date, nil); err != nil {
+		log.Printf("Error updating progress from sets: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := detectRecords(tx, userID, vars["id"], date); err != nil {
+		log.Printf("Error detecting records: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,651 @@
+package api
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/lib/pq"
+	"gopkg.in/yaml.v3"
+)
+
+// RoutineFile mirrors the layout of data/jobs/routines/routines.yaml.
+// Unit is the unit of every weight in the file (lb or kg, default lb).
+type RoutineFile struct {
+	Version         string              `yaml:"version"`
+	Unit            WeightUnit          `yaml:"unit"`
+	Routines        []RoutineSpec       `yaml:"routines"`
+	DefaultSchedule map[string][]string `yaml:"default_schedule"`
+}
+
+type RoutineSpec struct {
+	Name            string        `yaml:"name"`
+	Description     *string       `yaml:"description"`
+	Category        *string       `yaml:"category"`
+	Difficulty      *string       `yaml:"difficulty"`
+	DurationMinutes *int          `yaml:"duration_minutes"`
+	Workouts        []WorkoutSpec `yaml:"workouts"`
+}
+
+func (s RoutineSpec) input() RoutineInput {
+	return RoutineInput{
+		Name:            s.Name,
+		Description:     s.Description,
+		Category:        s.Category,
+		Difficulty:      s.Difficulty,
+		DurationMinutes: s.DurationMinutes,
+	}
+}
+
+// WorkoutSpec is a single workout entry in the YAML. Key is optional and
+// defaults to a slug of the name; it is what ties a YAML entry to an
+// existing workouts row across syncs, so renaming a workout without
+// setting Key creates a new row and retires the old one. Exercise is the
+// catalog slug and likewise defaults to a slug of the name.
+type WorkoutSpec struct {
+	Key           string           `yaml:"key"`
+	Exercise      string           `yaml:"exercise"`
+	Name          string           `yaml:"name"`
+	Type          *string          `yaml:"type"`
+	ExerciseType  string           `yaml:"exercise_type"`
+	DefaultWeight *float64         `yaml:"default_weight"`
+	DefaultTime   *int             `yaml:"default_time"`
+	Reps          *int             `yaml:"reps"`
+	Sets          *int             `yaml:"sets"`
+	Description   *string          `yaml:"description"`
+	Instructions  *string          `yaml:"instructions"`
+	MuscleGroups  []string         `yaml:"muscle_groups"`
+	Progression   *ProgressionRule `yaml:"progression"`
+}
+
+// input converts the spec to a workout, with weights given in unit
+// converted to pounds.
+func (s WorkoutSpec) input(unit WeightUnit) WorkoutInput {
+	in := WorkoutInput{
+		Name:         s.Name,
+		ExerciseType: ExerciseType(s.ExerciseType),
+		Weight:       s.DefaultWeight,
+		Time:         s.DefaultTime,
+		Reps:         s.Reps,
+		Sets:         s.Sets,
+		Description:  s.Description,
+		Instructions: s.Instructions,
+		MuscleGroups: s.MuscleGroups,
+	}
+	if s.Type != nil {
+		wt := WorkoutType(*s.Type)
+		in.Type = &wt
+	}
+	if s.Progression != nil {
+		rule := *s.Progression
+		in.Progression = &rule
+	}
+	in.toPounds(unit)
+	return in
+}
+
+func (s WorkoutSpec) exercise() string {
+	if s.Exercise != "" {
+		return s.Exercise
+	}
+	return workoutKey(s.Name)
+}
+
+func (s WorkoutSpec) key() string {
+	if s.Key != "" {
+		return s.Key
+	}
+	return workoutKey(s.Name)
+}
+
+// workoutKey derives the default sync key from a workout name,
+// e.g. "Pull-ups" -> "pull-ups", "Romanian Deadlifts" -> "romanian-deadlifts".
+func workoutKey(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+func LoadRoutineFile(path string) (*RoutineFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file RoutineFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if file.Unit == "" {
+		file.Unit = Pounds
+	}
+	if !file.Unit.Valid() {
+		return nil, fmt.Errorf("%s: unit must be lb or kg, got %q", path, file.Unit)
+	}
+
+	names := make(map[string]bool)
+	for i := range file.Routines {
+		routine := &file.Routines[i]
+		input := routine.input()
+		if err := input.validate(); err != nil {
+			return nil, fmt.Errorf("routines[%d]: %v", i, err)
+		}
+		routine.Name = input.Name
+		if names[routine.Name] {
+			return nil, fmt.Errorf("routine %q is defined more than once", routine.Name)
+		}
+		names[routine.Name] = true
+
+		keys := make(map[string]bool)
+		for j, workout := range routine.Workouts {
+			in := workout.input(file.Unit)
+			if err := in.validate(); err != nil {
+				return nil, fmt.Errorf("routine %q workouts[%d]: %v", routine.Name, j, err)
+			}
+			key := workout.key()
+			if key == "" {
+				return nil, fmt.Errorf("routine %q workouts[%d]: could not derive a key, set one explicitly", routine.Name, j)
+			}
+			if keys[key] {
+				return nil, fmt.Errorf("routine %q has more than one workout with key %q", routine.Name, key)
+			}
+			keys[key] = true
+		}
+	}
+
+	return &file, nil
+}
+
+type SyncAction string
+
+const (
+	SyncCreate    SyncAction = "create"
+	SyncUpdate    SyncAction = "update"
+	SyncRetire    SyncAction = "retire"
+	SyncUnchanged SyncAction = "unchanged"
+)
+
+type WorkoutChange struct {
+	Key       string
+	Name      string
+	WorkoutID string
+	Action    SyncAction
+	Changes   []string
+
+	input    WorkoutInput
+	exercise string
+	position int
+}
+
+type RoutineChange struct {
+	Name      string
+	RoutineID string
+	Action    SyncAction
+	Changes   []string
+	Workouts  []WorkoutChange
+
+	spec RoutineSpec
+}
+
+// SyncPlan is the diff between routines.yaml and the database. Routines
+// that exist only in the database are left alone; workouts that disappear
+// from a routine in the YAML are retired rather than deleted so that the
+// user_progress rows referencing them survive.
+type SyncPlan struct {
+	Routines []RoutineChange
+}
+
+// HasChanges reports whether applying the plan would modify the database.
+func (p *SyncPlan) HasChanges() bool {
+	for _, rc := range p.Routines {
+		if rc.Action != SyncUnchanged {
+			return true
+		}
+		for _, wc := range rc.Workouts {
+			if wc.Action != SyncUnchanged {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Write prints a human readable version of the plan.
+func (p *SyncPlan) Write(out io.Writer) {
+	counts := map[SyncAction]int{}
+	for _, rc := range p.Routines {
+		fmt.Fprintf(out, "%s routine %q", actionSymbol(rc.Action), rc.Name)
+		if len(rc.Changes) > 0 {
+			fmt.Fprintf(out, " (%s)", strings.Join(rc.Changes, ", "))
+		}
+		fmt.Fprintln(out)
+
+		for _, wc := range rc.Workouts {
+			counts[wc.Action]++
+			fmt.Fprintf(out, "    %s %s [%s]", actionSymbol(wc.Action), wc.Name, wc.Key)
+			if len(wc.Changes) > 0 {
+				fmt.Fprintf(out, " (%s)", strings.Join(wc.Changes, ", "))
+			}
+			fmt.Fprintln(out)
+		}
+	}
+	fmt.Fprintf(out, "\nWorkouts: %d to create, %d to update, %d to retire, %d unchanged\n",
+		counts[SyncCreate], counts[SyncUpdate], counts[SyncRetire], counts[SyncUnchanged])
+}
+
+func actionSymbol(a SyncAction) string {
+	switch a {
+	case SyncCreate:
+		return "+"
+	case SyncUpdate:
+		return "~"
+	case SyncRetire:
+		return "-"
+	}
+	return "="
+}
+
+type existingWorkout struct {
+	id       string
+	key      string
+	keySet   bool
+	exercise string
+	input    WorkoutInput
+	position int
+	retired  bool
+}
+
+func loadExistingWorkouts(q queryer, routineID string) ([]existingWorkout, error) {
+	rows, err := q.Query(`
+		SELECT w.id, w.sync_key, e.slug, w.name, w.type, w.exercise_type, w.weight, w.time, w.reps, w.sets,
+		       w.description, w.instructions, w.muscle_groups, w.progression, w.position,
+		       w.retired_at IS NOT NULL
+		FROM workouts w
+		LEFT JOIN exercises e ON e.id = w.exercise_id
+		WHERE w.routine_id = $1
+		ORDER BY w.retired_at IS NOT NULL, w.position, w.created_at`, routineID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workouts []existingWorkout
+	for rows.Next() {
+		var ew existingWorkout
+		var syncKey, exercise, workoutType, description, instructions sql.NullString
+		var weight sql.NullFloat64
+		var t, reps, sets sql.NullInt64
+		var progression []byte
+
+		err := rows.Scan(&ew.id, &syncKey, &exercise, &ew.input.Name, &workoutType, &ew.input.ExerciseType,
+			&weight, &t, &reps, &sets, &description, &instructions, pq.Array(&ew.input.MuscleGroups),
+			&progression, &ew.position, &ew.retired)
+		if err != nil {
+			return nil, err
+		}
+		if ew.input.Progression, err = parseProgression(progression); err != nil {
+			return nil, err
+		}
+
+		ew.key = syncKey.String
+		ew.keySet = syncKey.Valid
+		ew.exercise = exercise.String
+		if !syncKey.Valid {
+			ew.key = workoutKey(ew.input.Name)
+		}
+		if workoutType.Valid {
+			wt := WorkoutType(workoutType.String)
+			ew.input.Type = &wt
+		}
+		if weight.Valid {
+			ew.input.Weight = &weight.Float64
+		}
+		if t.Valid {
+			v := int(t.Int64)
+			ew.input.Time = &v
+		}
+		if reps.Valid {
+			v := int(reps.Int64)
+			ew.input.Reps = &v
+		}
+		if sets.Valid {
+			v := int(sets.Int64)
+			ew.input.Sets = &v
+		}
+		if description.Valid {
+			ew.input.Description = &description.String
+		}
+		if instructions.Valid {
+			ew.input.Instructions = &instructions.String
+		}
+		workouts = append(workouts, ew)
+	}
+	return workouts, rows.Err()
+}
+
+func planSync(q queryer, file *RoutineFile) (*SyncPlan, error) {
+	plan := &SyncPlan{}
+
+	for _, spec := range file.Routines {
+		rc := RoutineChange{Name: spec.Name, Action: SyncUnchanged, spec: spec}
+
+		var description sql.NullString
+		var meta routineMeta
+		var retired bool
+		err := q.QueryRow(`
+			SELECT id, description, category, difficulty, duration_minutes, retired_at IS NOT NULL
+			FROM routines WHERE name = $1 ORDER BY retired_at IS NOT NULL, created_at LIMIT 1`, spec.Name).
+			Scan(&rc.RoutineID, &description, &meta.category, &meta.difficulty, &meta.duration, &retired)
+		var existing []existingWorkout
+		switch {
+		case err == sql.ErrNoRows:
+			rc.Action = SyncCreate
+		case err != nil:
+			return nil, err
+		default:
+			var current Routine
+			meta.apply(&current)
+			// A routine deleted through the API comes back, with its history
+			if retired {
+				rc.Action = SyncUpdate
+				rc.Changes = append(rc.Changes, "restored")
+			}
+			for _, diff := range []string{
+				diffString("description", nullStringPtr(description), spec.Description),
+				diffString("category", current.Category, spec.Category),
+				diffString("difficulty", current.Difficulty, spec.Difficulty),
+				diffInt("duration_minutes", current.DurationMinutes, spec.DurationMinutes),
+			} {
+				if diff != "" {
+					rc.Action = SyncUpdate
+					rc.Changes = append(rc.Changes, diff)
+				}
+			}
+			existing, err = loadExistingWorkouts(q, rc.RoutineID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		matched := make(map[string]bool)
+		for pos, ws := range spec.Workouts {
+			wc := WorkoutChange{Key: ws.key(), Name: ws.Name, input: ws.input(file.Unit), exercise: ws.exercise(), position: pos}
+			wc.input.validate()
+
+			var match *existingWorkout
+			for i := range existing {
+				if existing[i].key == wc.Key && !matched[existing[i].id] {
+					match = &existing[i]
+					break
+				}
+			}
+
+			if match == nil {
+				wc.Action = SyncCreate
+			} else {
+				matched[match.id] = true
+				wc.WorkoutID = match.id
+				wc.Changes = diffWorkout(match, wc.input, wc.exercise, pos)
+				wc.Action = SyncUnchanged
+				if len(wc.Changes) > 0 {
+					wc.Action = SyncUpdate
+				}
+			}
+			rc.Workouts = append(rc.Workouts, wc)
+		}
+
+		for _, ew := range existing {
+			if matched[ew.id] || ew.retired {
+				continue
+			}
+			rc.Workouts = append(rc.Workouts, WorkoutChange{
+				Key:       ew.key,
+				Name:      ew.input.Name,
+				WorkoutID: ew.id,
+				Action:    SyncRetire,
+			})
+		}
+
+		plan.Routines = append(plan.Routines, rc)
+	}
+
+	return plan, nil
+}
+
+func diffWorkout(ew *existingWorkout, in WorkoutInput, exercise string, position int) []string {
+	var changes []string
+	if !ew.keySet {
+		changes = append(changes, fmt.Sprintf("key: set to %q", ew.key))
+	}
+	if ew.retired {
+		changes = append(changes, "restore retired workout")
+	}
+	if ew.input.Name != in.Name {
+		changes = append(changes, fmt.Sprintf("name: %q -> %q", ew.input.Name, in.Name))
+	}
+	if diff := diffString("type", (*string)(ew.input.Type), (*string)(in.Type)); diff != "" {
+		changes = append(changes, diff)
+	}
+	if ew.input.ExerciseType != in.ExerciseType {
+		changes = append(changes, fmt.Sprintf("exercise_type: %s -> %s", ew.input.ExerciseType, in.ExerciseType))
+	}
+	if diff := diffFloat("weight", ew.input.Weight, in.Weight); diff != "" {
+		changes = append(changes, diff)
+	}
+	if diff := diffInt("time", ew.input.Time, in.Time); diff != "" {
+		changes = append(changes, diff)
+	}
+	if diff := diffInt("reps", ew.input.Reps, in.Reps); diff != "" {
+		changes = append(changes, diff)
+	}
+	if diff := diffInt("sets", ew.input.Sets, in.Sets); diff != "" {
+		changes = append(changes, diff)
+	}
+	if diff := diffString("description", ew.input.Description, in.Description); diff != "" {
+		changes = append(changes, diff)
+	}
+	if diff := diffString("instructions", ew.input.Instructions, in.Instructions); diff != "" {
+		changes = append(changes, diff)
+	}
+	if old, new := strings.Join(ew.input.MuscleGroups, ", "), strings.Join(in.MuscleGroups, ", "); old != new {
+		changes = append(changes, fmt.Sprintf("muscle_groups: [%s] -> [%s]", old, new))
+	}
+	if diff := diffString("progression", progressionJSON(ew.input.Progression), progressionJSON(in.Progression)); diff != "" {
+		changes = append(changes, diff)
+	}
+	if ew.exercise != exercise {
+		changes = append(changes, fmt.Sprintf("exercise: %q -> %q", ew.exercise, exercise))
+	}
+	if ew.position != position {
+		changes = append(changes, fmt.Sprintf("position: %d -> %d", ew.position, position))
+	}
+	return changes
+}
+
+func nullStringPtr(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+func diffString(field string, old, new *string) string {
+	if old == nil && new == nil || old != nil && new != nil && *old == *new {
+		return ""
+	}
+	format := func(s *string) string {
+		if s == nil {
+			return "null"
+		}
+		return fmt.Sprintf("%q", *s)
+	}
+	return fmt.Sprintf("%s: %s -> %s", field, format(old), format(new))
+}
+
+func diffFloat(field string, old, new *float64) string {
+	if old == nil && new == nil || old != nil && new != nil && *old == *new {
+		return ""
+	}
+	format := func(f *float64) string {
+		if f == nil {
+			return "null"
+		}
+		return fmt.Sprintf("%g", *f)
+	}
+	return fmt.Sprintf("%s: %s -> %s", field, format(old), format(new))
+}
+
+func diffInt(field string, old, new *int) string {
+	if old == nil && new == nil || old != nil && new != nil && *old == *new {
+		return ""
+	}
+	format := func(i *int) string {
+		if i == nil {
+			return "null"
+		}
+		return fmt.Sprintf("%d", *i)
+	}
+	return fmt.Sprintf("%s: %s -> %s", field, format(old), format(new))
+}
+
+func applySync(tx *sql.Tx, plan *SyncPlan) error {
+	for i := range plan.Routines {
+		rc := &plan.Routines[i]
+
+		switch rc.Action {
+		case SyncCreate:
+			id, err := insertRoutine(tx, rc.spec.input())
+			if err != nil {
+				return fmt.Errorf("creating routine %q: %v", rc.Name, err)
+			}
+			rc.RoutineID = id
+		case SyncUpdate:
+			if err := updateRoutine(tx, rc.RoutineID, rc.spec.input()); err != nil {
+				return fmt.Errorf("updating routine %q: %v", rc.Name, err)
+			}
+		}
+
+		workoutsChanged := false
+		for j := range rc.Workouts {
+			wc := &rc.Workouts[j]
+			in := wc.input
+			workoutsChanged = workoutsChanged || wc.Action != SyncUnchanged
+
+			var err error
+			switch wc.Action {
+			case SyncCreate:
+				if err = linkExercise(tx, &in, wc.exercise); err == nil {
+					wc.WorkoutID, err = insertWorkoutAt(tx, rc.RoutineID, wc.Key, wc.position, in)
+				}
+			case SyncUpdate:
+				if err = linkExercise(tx, &in, wc.exercise); err == nil {
+					err = updateWorkout(tx, wc.WorkoutID, in)
+				}
+				if err == nil {
+					_, err = tx.Exec(`
+						UPDATE workouts SET sync_key = $2, position = $3, retired_at = NULL
+						WHERE id = $1`, wc.WorkoutID, wc.Key, wc.position)
+				}
+			case SyncRetire:
+				_, err = tx.Exec(`
+					UPDATE workouts
+					SET sync_key = $2, retired_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+					WHERE id = $1`, wc.WorkoutID, wc.Key)
+			}
+			if err != nil {
+				return fmt.Errorf("%s workout %q in routine %q: %v", wc.Action, wc.Name, rc.Name, err)
+			}
+		}
+
+		// Workout changes are changes to the routine clients hold an ETag for
+		if rc.Action != SyncCreate && workoutsChanged {
+			if _, err := touchRoutine(tx, rc.RoutineID); err != nil {
+				return fmt.Errorf("updating routine %q: %v", rc.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// SyncRoutines diffs file against the database and, unless dryRun is set,
+// applies the resulting plan. Planning and applying happen in a single
+// transaction so the plan cannot go stale before it is applied.
+func (db *DB) SyncRoutines(file *RoutineFile, dryRun bool) (*SyncPlan, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	plan, err := planSync(tx, file)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return plan, nil
+	}
+
+	if plan.HasChanges() {
+		if err := applySync(tx, plan); err != nil {
+			return nil, err
+		}
+	}
+	// The server links older workouts when it creates its tables, which
+	// deployments that only run sync (main-k8s.go) never do
+	linked, err := linkExercises(tx)
+	if err != nil {
+		return nil, err
+	}
+	if linked > 0 {
+		log.Printf("Linked %d workouts to the exercise catalog", linked)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// RunSyncCommand implements the `sync` subcommand of the server binary.
+func (db *DB) RunSyncCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	fs.SetOutput(out)
+	file := fs.String("file", "data/jobs/routines/routines.yaml", "path to the routines YAML file")
+	dryRun := fs.Bool("dry-run", false, "print the plan without changing the database")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	routines, err := LoadRoutineFile(*file)
+	if err != nil {
+		return err
+	}
+
+	plan, err := db.SyncRoutines(routines, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	plan.Write(out)
+	switch {
+	case *dryRun:
+		log.Println("Dry run - no changes were made")
+	case !plan.HasChanges():
+		log.Println("Routines already up to date")
+	default:
+		log.Println("Routine sync applied successfully")
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// Weights are stored in pounds, the unit everything was logged in before
+// units existed, and converted to the user's unit at the API boundary:
+// request bodies with toPounds before they are written, responses with
+// inUnit just before they are sent.
+
+const kgPerPound = 0.45359237
+
+func parseWeightUnit(s string) (WeightUnit, error) {
+	u := WeightUnit(s)
+	if !u.Valid() {
+		return "", fmt.Errorf("unit must be lb or kg, got %q", s)
+	}
+	return u, nil
+}
+
+// userUnit returns the unit a user's weights are shown and entered in. A
+// unit sent with the request wins over the stored preference; unknown users
+// get pounds. userID may be an email or UUID.
+func (db *DB) userUnit(userID, override string) (WeightUnit, error) {
+	if override != "" {
+		return parseWeightUnit(override)
+	}
+
+	var unit WeightUnit
+	err := db.QueryRow(`SELECT weight_unit FROM users WHERE id::text = $1 OR email = $1 LIMIT 1`, userID).
+		Scan(&unit)
+	if err == sql.ErrNoRows {
+		return Pounds, nil
+	}
+	return unit, err
+}
+
+// requireUnit resolves the weight unit for a request from ?unit= or the
+// user's preference, writing a 400 response and returning false if the
+// unit is invalid.
+func (db *DB) requireUnit(w http.ResponseWriter, r *http.Request, userID string) (WeightUnit, bool) {
+	unit, err := db.userUnit(userID, r.URL.Query().Get("unit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return unit, true
+}
+
+// fromPounds converts a stored weight to u, to the nearest hundredth.
+func (u WeightUnit) fromPounds(v float64) float64 {
+	if u != Kilograms {
+		return v
+	}
+	return math.Round(v*kgPerPound*100) / 100
+}
+
+// weight converts a stored weight to u without changing the original.
+func (u WeightUnit) weight(v *float64) *float64 {
+	if v == nil {
+		return nil
+	}
+	c := u.fromPounds(*v)
+	return &c
+}
+
+// pounds converts a weight entered in u to pounds for storage.
+func (u WeightUnit) pounds(v *float64) *float64 {
+	if v == nil || u != Kilograms {
+		return v
+	}
+	c := math.Round(*v/kgPerPound*100) / 100
+	return &c
+}
+
+// edited converts an edited weight to pounds. A client that sends back the
+// value it was shown keeps the stored one, so saving an unrelated change
+// doesn't shift weights by rounding.
+func (u WeightUnit) edited(sent, stored *float64) *float64 {
+	if sameValue(sent, u.weight(stored)) {
+		return stored
+	}
+	return u.pounds(sent)
+}
+
+func (r *ProgressionRule) inUnit(u WeightUnit) {
+	if r != nil {
+		r.WeightIncrement = u.weight(r.WeightIncrement)
+	}
+}
+
+func (r *ProgressionRule) toPounds(u WeightUnit) {
+	if r != nil {
+		r.WeightIncrement = u.pounds(r.WeightIncrement)
+	}
+}
+
+func (w *Workout) inUnit(u WeightUnit) {
+	w.Weight = u.weight(w.Weight)
+	w.UserWeight = u.weight(w.UserWeight)
+	w.SuggestedWeight = u.weight(w.SuggestedWeight)
+	w.Progression.inUnit(u)
+}
+
+func (in *WorkoutInput) toPounds(u WeightUnit) {
+	in.Weight = u.pounds(in.Weight)
+	in.Progression.toPounds(u)
+}
+
+// shownIn returns a copy of a stored workout with its weights in u, to be
+// edited by a PATCH.
+func (in WorkoutInput) shownIn(u WeightUnit) WorkoutInput {
+	in.Weight = u.weight(in.Weight)
+	if in.Progression != nil {
+		rule := *in.Progression
+		rule.inUnit(u)
+		in.Progression = &rule
+	}
+	return in
+}
+
+// editedFrom converts the weights of an edited workout to pounds, keeping
+// the stored values the client sent back unchanged.
+func (in *WorkoutInput) editedFrom(stored *WorkoutInput, u WeightUnit) {
+	in.Weight = u.edited(in.Weight, stored.Weight)
+	if in.Progression != nil {
+		var increment *float64
+		if stored.Progression != nil {
+			increment = stored.Progression.WeightIncrement
+		}
+		in.Progression.WeightIncrement = u.edited(in.Progression.WeightIncrement, increment)
+	}
+}
+
+func (r *Routine) inUnit(u WeightUnit) {
+	for i := range r.Workouts {
+		r.Workouts[i].inUnit(u)
+	}
+}
+
+func (in *RoutineInput) toPounds(u WeightUnit) {
+	for i := range in.Workouts {
+		in.Workouts[i].toPounds(u)
+	}
+}
+
+func (s *WeekSchedule) inUnit(u WeightUnit) {
+	for i := range s.Schedule {
+		for j := range s.Schedule[i].Routines {
+			s.Schedule[i].Routines[j].inUnit(u)
+		}
+	}
+}
+
+func (p *UserProgress) inUnit(u WeightUnit) {
+	p.Weight = u.weight(p.Weight)
+}
+
+func (s *WorkoutSet) inUnit(u WeightUnit) {
+	s.Weight = u.weight(s.Weight)
+}
+
+func (l *WorkoutSetLog) inUnit(u WeightUnit) {
+	l.UserWeight = u.weight(l.UserWeight)
+	for i := range l.Sets {
+		l.Sets[i].inUnit(u)
+	}
+	for i := range l.Records {
+		l.Records[i].inUnit(u)
+	}
+}
+
+func (s *WorkoutSession) inUnit(u WeightUnit) {
+	for i := range s.Progress {
+		s.Progress[i].inUnit(u)
+	}
+}
+
+func (s *ProgressSnapshot) inUnit(u WeightUnit) {
+	s.Progress.inUnit(u)
+	for i := range s.Sets {
+		s.Sets[i].inUnit(u)
+	}
+}
+
+// inUnit converts the weights of a record. Value and PreviousValue are
+// weights only for weight and e1rm records.
+func (pr *PersonalRecord) inUnit(u WeightUnit) {
+	pr.Weight = u.weight(pr.Weight)
+	if pr.Type == RecordWeight || pr.Type == RecordE1RM {
+		pr.Value = u.fromPounds(pr.Value)
+		pr.PreviousValue = u.weight(pr.PreviousValue)
+	}
+}
+
+func (h *ExerciseHistory) inUnit(u WeightUnit) {
+	h.BestWeight = u.weight(h.BestWeight)
+	for i := range h.Entries {
+		h.Entries[i].Weight = u.weight(h.Entries[i].Weight)
+	}
+}
+
+func (t *StrengthTrend) inUnit(u WeightUnit) {
+	for i := range t.Points {
+		p := &t.Points[i]
+		p.E1RM = u.fromPounds(p.E1RM)
+		p.Weight = u.fromPounds(p.Weight)
+		p.Trend = u.fromPounds(p.Trend)
+		p.Bodyweight = u.weight(p.Bodyweight)
+	}
+	t.Best = u.fromPounds(t.Best)
+	t.Latest = u.fromPounds(t.Latest)
+	t.ChangePerWeek = u.fromPounds(t.ChangePerWeek)
+}
+
+func (v *VolumeAnalytics) inUnit(u WeightUnit) {
+	for i := range v.Weeks {
+		for j := range v.Weeks[i].MuscleGroups {
+			g := &v.Weeks[i].MuscleGroups[j]
+			g.Tonnage = u.fromPounds(g.Tonnage)
+		}
+	}
+	for i := range v.Totals {
+		v.Totals[i].Tonnage = u.fromPounds(v.Totals[i].Tonnage)
+	}
+}
+
+// inUnit converts an insight and rewrites its suggestion in u. A suggested
+// deload is rounded down to a plate in the new unit.
+func (in *Insight) inUnit(u WeightUnit) {
+	if in.Metric == "e1rm" {
+		in.Best = u.fromPounds(in.Best)
+		in.Latest = u.fromPounds(in.Latest)
+	}
+	if in.SuggestedWeight != nil && u != Pounds {
+		w := roundToPlate(u.fromPounds(*in.SuggestedWeight))
+		in.SuggestedWeight = &w
+	}
+	in.Suggestion = in.suggestion(u)
+}
+
+func (m *BodyMetrics) inUnit(u WeightUnit) {
+	m.Bodyweight = u.weight(m.Bodyweight)
+}
+
+// inUnit converts a series if its metric is a weight.
+func (s *BodyMetricSeries) inUnit(u WeightUnit) {
+	if s.Metric != "bodyweight" {
+		return
+	}
+	for i := range s.Points {
+		s.Points[i].Value = u.fromPounds(s.Points[i].Value)
+		s.Points[i].Trend = u.fromPounds(s.Points[i].Trend)
+	}
+	s.Latest = u.weight(s.Latest)
+	s.Change = u.fromPounds(s.Change)
+	s.ChangePerWeek = u.fromPounds(s.ChangePerWeek)
+}
+
+func (c *SyncChanges) inUnit(u WeightUnit) {
+	for i := range c.Progress {
+		c.Progress[i].inUnit(u)
+	}
+	for i := range c.Sessions {
+		c.Sessions[i].inUnit(u)
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// loadUser returns a user by email or UUID.
+func (db *DB) loadUser(identifier string) (*User, error) {
+	userID, err := db.resolveUserID(identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	var u User
+	err = db.QueryRow(`
+		SELECT id, email, COALESCE(name, ''), timezone, weight_unit, created_at, updated_at
+		FROM users WHERE id = $1`, userID).
+		Scan(&u.ID, &u.Email, &u.Name, &u.Timezone, &u.WeightUnit, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (db *DB) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, err := db.loadUser(mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+// UpdateUser changes a user's name and preferences. Only the fields present
+// in the body are updated.
+func (db *DB) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	current, err := db.loadUser(mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body := struct {
+		Name       string     `json:"name"`
+		Timezone   string     `json:"timezone"`
+		WeightUnit WeightUnit `json:"weight_unit"`
+	}{Name: current.Name, Timezone: current.Timezone, WeightUnit: current.WeightUnit}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := loadLocation(body.Timezone); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := parseWeightUnit(string(body.WeightUnit)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = db.Exec(`
+		UPDATE users SET name = COALESCE(NULLIF($2, ''), name), timezone = $3, weight_unit = $4,
+		                 updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, current.ID, body.Name, body.Timezone, body.WeightUnit)
+	if err != nil {
+		log.Printf("Error updating user: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	user, err := db.loadUser(current.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Progress entries, routines and week schedules carry a version that is
+// bumped on every write. It is sent as the ETag, and writes that send it
+// back in If-Match only succeed if nobody changed the row in between.
+
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", etag(version))
+}
+
+// versionMatches reports whether the request's If-Match header allows a
+// write to a row at version. Requests without If-Match, or with "*", are
+// always allowed; weak validators (W/"3") are compared like strong ones.
+func versionMatches(r *http.Request, version int) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag(version) {
+			return true
+		}
+	}
+	return false
+}
+
+// lockVersion locks a row of table for the rest of the transaction and
+// returns its id and current version.
+func lockVersion(q queryer, table, id string) (string, int, error) {
+	var rowID string
+	var version int
+	err := q.QueryRow(fmt.Sprintf(`SELECT id, version FROM %s WHERE id::text = $1 FOR UPDATE`, table), id).
+		Scan(&rowID, &version)
+	return rowID, version, err
+}
+
+// writeVersionConflict answers a write whose If-Match is stale with 409,
+// the current state of the row and its ETag.
+func writeVersionConflict(w http.ResponseWriter, version int, current interface{}) {
+	setETag(w, version)
+	writeJSON(w, http.StatusConflict, current)
+}
+
+// lockRoutine locks a routine for a change, writing a 404 if it doesn't
+// exist or a 409 with the current routine if If-Match is stale.
+func (db *DB) lockRoutine(w http.ResponseWriter, r *http.Request, q queryer, routineID string) (string, bool) {
+	var id string
+	var version int
+	err := q.QueryRow(`SELECT id, version FROM routines WHERE id::text = $1 AND retired_at IS NULL FOR UPDATE`, routineID).
+		Scan(&id, &version)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Routine not found", http.StatusNotFound)
+		return "", false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	if !versionMatches(r, version) {
+		current, err := db.loadRoutine(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return "", false
+		}
+		// The handler has already checked the unit
+		unit, _ := db.userUnit(r.URL.Query().Get("user_id"), r.URL.Query().Get("unit"))
+		current.inUnit(unit)
+		writeVersionConflict(w, version, current)
+		return "", false
+	}
+	return id, true
+}
+
+// touchRoutine records a change to a routine's workouts and returns the
+// routine's new version.
+func touchRoutine(q queryer, routineID string) (int, error) {
+	var version int
+	err := q.QueryRow(`
+		UPDATE routines SET version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING version`, routineID).Scan(&version)
+	return version, err
+}
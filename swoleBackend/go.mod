@@ -7,4 +7,5 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/lib/pq v1.10.9
 	github.com/rs/cors v1.10.1
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -10,6 +10,8 @@ CREATE TABLE IF NOT EXISTS users (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     email VARCHAR(255) UNIQUE NOT NULL,
     name VARCHAR(255),
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    weight_unit VARCHAR(2) NOT NULL DEFAULT 'lb',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -17,8 +19,24 @@ CREATE TABLE IF NOT EXISTS users (
 -- Routines table
 CREATE TABLE IF NOT EXISTS routines (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-    name VARCHAR(255) UNIQUE NOT NULL,
+    name VARCHAR(255) NOT NULL,
     description TEXT,
+    category VARCHAR(50),
+    difficulty VARCHAR(50),
+    duration_minutes INTEGER,
+    version INTEGER NOT NULL DEFAULT 1,
+    retired_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Exercise catalog shared by workouts across routines
+CREATE TABLE IF NOT EXISTS exercises (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    slug VARCHAR(255) UNIQUE NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    type VARCHAR(50),
+    exercise_type VARCHAR(50) NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -27,6 +45,7 @@ CREATE TABLE IF NOT EXISTS routines (
 CREATE TABLE IF NOT EXISTS workouts (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     routine_id UUID REFERENCES routines(id) ON DELETE CASCADE,
+    exercise_id UUID REFERENCES exercises(id) ON DELETE SET NULL,
     name VARCHAR(255) NOT NULL,
     type VARCHAR(50),
     exercise_type VARCHAR(50) NOT NULL,
@@ -35,6 +54,12 @@ CREATE TABLE IF NOT EXISTS workouts (
     reps INTEGER,
     sets INTEGER,
     description TEXT,
+    instructions TEXT,
+    muscle_groups TEXT[],
+    progression JSONB,
+    position INTEGER NOT NULL DEFAULT 0,
+    sync_key VARCHAR(255),
+    retired_at TIMESTAMP,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -44,8 +69,10 @@ CREATE TABLE IF NOT EXISTS week_schedules (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     user_id UUID REFERENCES users(id) ON DELETE CASCADE,
     week_start DATE NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, week_start)
 );
 
 -- Day schedules table
@@ -65,6 +92,18 @@ CREATE TABLE IF NOT EXISTS day_routines (
     PRIMARY KEY (day_id, routine_id)
 );
 
+-- Workout sessions (a single training session from start to finish)
+CREATE TABLE IF NOT EXISTS workout_sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    routine_id UUID REFERENCES routines(id) ON DELETE SET NULL,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ended_at TIMESTAMPTZ,
+    notes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
 -- User progress table (preserves user workout progress)
 CREATE TABLE IF NOT EXISTS user_progress (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -72,16 +111,187 @@ CREATE TABLE IF NOT EXISTS user_progress (
     workout_id UUID REFERENCES workouts(id) ON DELETE CASCADE,
     weight DECIMAL,
     time INTEGER,
+    session_id UUID REFERENCES workout_sessions(id) ON DELETE SET NULL,
     date DATE NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     UNIQUE(user_id, workout_id, date)
 );
 
+-- Individual sets logged per workout per day
+CREATE TABLE IF NOT EXISTS workout_sets (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    workout_id UUID NOT NULL REFERENCES workouts(id) ON DELETE CASCADE,
+    date DATE NOT NULL,
+    set_number INTEGER NOT NULL,
+    reps INTEGER,
+    weight DECIMAL,
+    duration INTEGER,
+    rpe DECIMAL(3,1),
+    is_warmup BOOLEAN NOT NULL DEFAULT FALSE,
+    session_id UUID REFERENCES workout_sessions(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, workout_id, date, set_number)
+);
+
+-- Personal bests detected when progress is logged
+CREATE TABLE IF NOT EXISTS personal_records (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    exercise_id UUID NOT NULL REFERENCES exercises(id) ON DELETE CASCADE,
+    workout_id UUID NOT NULL REFERENCES workouts(id) ON DELETE CASCADE,
+    record_type VARCHAR(20) NOT NULL,
+    value DECIMAL NOT NULL,
+    previous_value DECIMAL,
+    weight DECIMAL,
+    reps INTEGER,
+    date DATE NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS insights (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    exercise_id UUID NOT NULL REFERENCES exercises(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL,
+    metric VARCHAR(20) NOT NULL,
+    sessions INTEGER NOT NULL,
+    best DECIMAL NOT NULL,
+    latest DECIMAL NOT NULL,
+    change_percent DECIMAL NOT NULL,
+    suggestion TEXT NOT NULL,
+    suggested_weight DECIMAL,
+    detected_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, exercise_id, kind)
+);
+
+CREATE TABLE IF NOT EXISTS progress_audit (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    progress_id UUID NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    action VARCHAR(10) NOT NULL,
+    snapshot JSONB NOT NULL,
+    restored_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sync_mutations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_id VARCHAR(100) NOT NULL,
+    mutation_type VARCHAR(30) NOT NULL,
+    entity_id UUID,
+    result JSONB NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, client_id)
+);
+
+CREATE TABLE IF NOT EXISTS body_measurements (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    date DATE NOT NULL,
+    bodyweight DECIMAL,
+    body_fat DECIMAL(4,1),
+    neck DECIMAL,
+    chest DECIMAL,
+    waist DECIMAL,
+    hips DECIMAL,
+    arm DECIMAL,
+    thigh DECIMAL,
+    calf DECIMAL,
+    notes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, date)
+);
+
+-- Multi-week training programs
+CREATE TABLE IF NOT EXISTS programs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Ordered weeks of a program, with their load and rep modifiers
+CREATE TABLE IF NOT EXISTS program_weeks (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    program_id UUID NOT NULL REFERENCES programs(id) ON DELETE CASCADE,
+    week_number INTEGER NOT NULL,
+    name VARCHAR(255),
+    load_percent DECIMAL NOT NULL DEFAULT 100,
+    rep_change INTEGER NOT NULL DEFAULT 0,
+    UNIQUE(program_id, week_number)
+);
+
+-- Routines planned on each day of a program week
+CREATE TABLE IF NOT EXISTS program_week_routines (
+    week_id UUID NOT NULL REFERENCES program_weeks(id) ON DELETE CASCADE,
+    day VARCHAR(20) NOT NULL,
+    routine_id UUID NOT NULL REFERENCES routines(id) ON DELETE CASCADE,
+    position INTEGER DEFAULT 0,
+    PRIMARY KEY (week_id, day, routine_id)
+);
+
+-- Users following a program from the week of start_date
+CREATE TABLE IF NOT EXISTS program_enrollments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    program_id UUID NOT NULL REFERENCES programs(id) ON DELETE CASCADE,
+    start_date DATE NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Routines a user cycles through in order, regardless of weekday
+CREATE TABLE IF NOT EXISTS rotations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255),
+    position INTEGER NOT NULL DEFAULT 0,
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS rotation_routines (
+    rotation_id UUID NOT NULL REFERENCES rotations(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    routine_id UUID NOT NULL REFERENCES routines(id) ON DELETE CASCADE,
+    PRIMARY KEY (rotation_id, position)
+);
+
+-- Routines planned by RFC 5545 recurrence rule rather than by weekday
+CREATE TABLE IF NOT EXISTS routine_recurrences (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    routine_id UUID NOT NULL REFERENCES routines(id) ON DELETE CASCADE,
+    rrule TEXT NOT NULL,
+    start_date DATE NOT NULL,
+    exceptions DATE[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
 -- Create indexes for better performance
 CREATE INDEX IF NOT EXISTS idx_workouts_routine_id ON workouts(routine_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_routines_active_name ON routines(name) WHERE retired_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_workouts_routine_position ON workouts(routine_id, position);
+CREATE INDEX IF NOT EXISTS idx_workouts_exercise_id ON workouts(exercise_id);
 CREATE INDEX IF NOT EXISTS idx_user_progress_user_id ON user_progress(user_id);
 CREATE INDEX IF NOT EXISTS idx_user_progress_workout_id ON user_progress(workout_id);
 CREATE INDEX IF NOT EXISTS idx_user_progress_date ON user_progress(date);
+CREATE INDEX IF NOT EXISTS idx_workout_sets_user_workout_date ON workout_sets(user_id, workout_id, date);
+CREATE INDEX IF NOT EXISTS idx_workout_sessions_user_started ON workout_sessions(user_id, started_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_workout_sessions_user_open ON workout_sessions(user_id) WHERE ended_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_personal_records_user_exercise ON personal_records(user_id, exercise_id, date);
+CREATE INDEX IF NOT EXISTS idx_progress_audit_user_created ON progress_audit(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_program_enrollments_user_start ON program_enrollments(user_id, start_date);
+CREATE INDEX IF NOT EXISTS idx_routine_recurrences_user_id ON routine_recurrences(user_id);
 CREATE INDEX IF NOT EXISTS idx_week_schedules_user_id ON week_schedules(user_id);
 CREATE INDEX IF NOT EXISTS idx_day_schedules_week_id ON day_schedules(week_id);
 
@@ -18,8 +18,7 @@ func main() {
 		log.Println("No .env file found, using environment variables from K8s")
 	}
 
-	// Initialize database connection only
-	// Tables are created by K8s init scripts
+	// Initialize database connection
 	db, err := api.InitDB()
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
@@ -27,7 +26,24 @@ func main() {
 	defer db.Close()
 
 	log.Println("Successfully connected to PostgreSQL database!")
-	log.Println("Assuming database schema is initialized by K8s init scripts")
+
+	// Tables are created by K8s init scripts on a fresh volume; this applies
+	// the migrations an existing database is missing
+	err = db.CreateTables()
+	if err != nil {
+		log.Fatal("Failed to migrate database:", err)
+	}
+
+	// `main sync [--dry-run] [--file path]` syncs routines.yaml and exits
+	if len(os.Args) > 1 && os.Args[1] == "sync" {
+		if err := db.RunSyncCommand(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal("Routine sync failed:", err)
+		}
+		return
+	}
+
+	// Re-analyse training history for plateaus in the background
+	db.StartInsightsWorker(os.Getenv("INSIGHTS_INTERVAL"))
 
 	// Create router
 	r := mux.NewRouter()
@@ -37,10 +53,68 @@ func main() {
 	
 	// Workout routes
 	apiRouter.HandleFunc("/week-schedule", db.GetWeekSchedule).Methods("GET")
+	apiRouter.HandleFunc("/day-schedule", db.GetDaySchedule).Methods("GET")
+	apiRouter.HandleFunc("/week-schedule", db.ReplaceWeekSchedule).Methods("PUT")
+	apiRouter.HandleFunc("/week-schedule/days/{day}/routines", db.AddScheduledRoutine).Methods("POST")
+	apiRouter.HandleFunc("/week-schedule/days/{day}/routines/{routineId}", db.RemoveScheduledRoutine).Methods("DELETE")
+	apiRouter.HandleFunc("/week-schedule/days/{day}/routine-order", db.ReorderScheduledRoutines).Methods("PUT")
+	apiRouter.HandleFunc("/programs", db.GetPrograms).Methods("GET")
+	apiRouter.HandleFunc("/programs", db.CreateProgram).Methods("POST")
+	apiRouter.HandleFunc("/programs/{id}", db.GetProgram).Methods("GET")
+	apiRouter.HandleFunc("/programs/{id}", db.UpdateProgram).Methods("PUT")
+	apiRouter.HandleFunc("/programs/{id}", db.DeleteProgram).Methods("DELETE")
+	apiRouter.HandleFunc("/program-enrollments/{id}", db.DeleteProgramEnrollment).Methods("DELETE")
 	apiRouter.HandleFunc("/routines", db.GetRoutines).Methods("GET")
+	apiRouter.HandleFunc("/routines", db.CreateRoutine).Methods("POST")
 	apiRouter.HandleFunc("/routines/{id}", db.GetRoutine).Methods("GET")
+	apiRouter.HandleFunc("/routines/{id}", db.UpdateRoutine).Methods("PUT", "PATCH")
+	apiRouter.HandleFunc("/routines/{id}", db.DeleteRoutine).Methods("DELETE")
+	apiRouter.HandleFunc("/routines/{id}/workouts", db.GetRoutineWorkouts).Methods("GET")
+	apiRouter.HandleFunc("/routines/{id}/workouts", db.CreateRoutineWorkout).Methods("POST")
+	apiRouter.HandleFunc("/routines/{id}/workout-order", db.ReorderRoutineWorkouts).Methods("PUT")
+	apiRouter.HandleFunc("/routines/{id}/workouts/{workoutId}", db.UpdateRoutineWorkout).Methods("PUT", "PATCH")
+	apiRouter.HandleFunc("/routines/{id}/workouts/{workoutId}", db.DeleteRoutineWorkout).Methods("DELETE")
+	apiRouter.HandleFunc("/exercises", db.GetExercises).Methods("GET")
+	apiRouter.HandleFunc("/exercises/{id}/history", db.GetExerciseHistory).Methods("GET")
 	apiRouter.HandleFunc("/workouts/{id}/progress", db.UpdateWorkoutProgress).Methods("POST")
+	apiRouter.HandleFunc("/workouts/{id}/sets", db.LogWorkoutSets).Methods("POST")
+	apiRouter.HandleFunc("/workouts/{id}/sets", db.GetWorkoutSets).Methods("GET")
+	apiRouter.HandleFunc("/workouts/{id}/sets/{setId}", db.DeleteWorkoutSet).Methods("DELETE")
 	apiRouter.HandleFunc("/progress", db.GetUserProgress).Methods("GET")
+	apiRouter.HandleFunc("/progress/audit", db.GetProgressAudit).Methods("GET")
+	apiRouter.HandleFunc("/progress/{id}", db.UpdateProgress).Methods("PUT")
+	apiRouter.HandleFunc("/progress/{id}", db.DeleteProgress).Methods("DELETE")
+	apiRouter.HandleFunc("/progress/{id}/restore", db.RestoreProgress).Methods("POST")
+	apiRouter.HandleFunc("/sessions", db.StartSession).Methods("POST")
+	apiRouter.HandleFunc("/sessions", db.GetSessions).Methods("GET")
+	apiRouter.HandleFunc("/sessions/{id}", db.GetSession).Methods("GET")
+	apiRouter.HandleFunc("/sessions/{id}", db.UpdateSession).Methods("PATCH")
+	apiRouter.HandleFunc("/sync", db.Sync).Methods("POST")
+	apiRouter.HandleFunc("/users/{id}", db.GetUser).Methods("GET")
+	apiRouter.HandleFunc("/users/{id}", db.UpdateUser).Methods("PATCH")
+	apiRouter.HandleFunc("/users/{id}/records", db.GetUserRecords).Methods("GET")
+	apiRouter.HandleFunc("/users/{id}/adherence", db.GetUserAdherence).Methods("GET")
+	apiRouter.HandleFunc("/users/{id}/insights", db.GetUserInsights).Methods("GET")
+	apiRouter.HandleFunc("/users/{id}/measurements", db.GetMeasurements).Methods("GET")
+	apiRouter.HandleFunc("/users/{id}/measurements", db.CreateMeasurement).Methods("POST")
+	apiRouter.HandleFunc("/users/{id}/measurements/series", db.GetMeasurementSeries).Methods("GET")
+	apiRouter.HandleFunc("/users/{id}/program-enrollments", db.GetProgramEnrollments).Methods("GET")
+	apiRouter.HandleFunc("/users/{id}/program-enrollments", db.EnrollInProgram).Methods("POST")
+	apiRouter.HandleFunc("/users/{id}/rotation", db.GetRotation).Methods("GET")
+	apiRouter.HandleFunc("/users/{id}/rotation", db.SetRotation).Methods("PUT")
+	apiRouter.HandleFunc("/users/{id}/rotation", db.DeleteRotation).Methods("DELETE")
+	apiRouter.HandleFunc("/users/{id}/rotation/next", db.GetNextRoutine).Methods("GET")
+	apiRouter.HandleFunc("/users/{id}/recurrences", db.GetRecurrences).Methods("GET")
+	apiRouter.HandleFunc("/users/{id}/recurrences", db.CreateRecurrence).Methods("POST")
+	apiRouter.HandleFunc("/users/{id}/occurrences", db.GetOccurrences).Methods("GET")
+	apiRouter.HandleFunc("/recurrences/{id}", db.GetRecurrence).Methods("GET")
+	apiRouter.HandleFunc("/recurrences/{id}", db.UpdateRecurrence).Methods("PUT")
+	apiRouter.HandleFunc("/recurrences/{id}", db.DeleteRecurrence).Methods("DELETE")
+	apiRouter.HandleFunc("/measurements/{id}", db.GetMeasurement).Methods("GET")
+	apiRouter.HandleFunc("/measurements/{id}", db.UpdateMeasurement).Methods("PUT")
+	apiRouter.HandleFunc("/measurements/{id}", db.DeleteMeasurement).Methods("DELETE")
+	apiRouter.HandleFunc("/analytics/strength", db.GetStrengthAnalytics).Methods("GET")
+	apiRouter.HandleFunc("/analytics/volume", db.GetVolumeAnalytics).Methods("GET")
 
 	// Health check
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -68,6 +142,7 @@ func main() {
 			http.MethodGet,
 			http.MethodPost,
 			http.MethodPut,
+			http.MethodPatch,
 			http.MethodDelete,
 			http.MethodOptions,
 		},
@@ -75,6 +150,10 @@ func main() {
 			"Content-Type",
 			"Authorization",
 			"X-Requested-With",
+			"If-Match",
+		},
+		ExposedHeaders: []string{
+			"ETag",
 		},
 		AllowCredentials: true,
 	})
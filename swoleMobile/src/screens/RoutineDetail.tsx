@@ -101,8 +101,8 @@ const RoutineDetail: React.FC<RoutineDetailProps> = ({ route, navigation }) => {
 
   const openUpdateModal = (workout: Workout) => {
     setSelectedWorkout(workout);
-    setUpdatedWeight((workout.userWeight || workout.weight || '').toString());
-    setUpdatedTime((workout.userTime || workout.time || '').toString());
+    setUpdatedWeight((workout.userWeight || workout.suggestedWeight || workout.weight || '').toString());
+    setUpdatedTime((workout.userTime || workout.suggestedTime || workout.time || '').toString());
     setModalVisible(true);
     
     Animated.spring(modalScaleAnim, {
@@ -132,7 +132,7 @@ const RoutineDetail: React.FC<RoutineDetailProps> = ({ route, navigation }) => {
       const userWeight = updatedWeight ? parseFloat(updatedWeight) : undefined;
       const userTime = updatedTime ? parseFloat(updatedTime) : undefined;
 
-      await ApiService.updateWorkoutProgress(
+      const result = await ApiService.updateWorkoutProgress(
         selectedWorkout.id,
         userWeight,
         userTime
@@ -151,7 +151,13 @@ const RoutineDetail: React.FC<RoutineDetailProps> = ({ route, navigation }) => {
 
       setWorkouts(updatedWorkouts);
       closeModal();
-      Alert.alert('Success', 'Progress updated successfully!');
+      if (result.status === 'queued') {
+        Alert.alert('Saved Offline', 'Your progress will sync when you are back online.');
+      } else if (result.records && result.records.length > 0) {
+        Alert.alert('New Personal Record! 🎉', `You set a new best for ${selectedWorkout.name}!`);
+      } else {
+        Alert.alert('Success', 'Progress updated successfully!');
+      }
     } catch (error) {
       console.error('Failed to save progress:', error);
       Alert.alert('Error', 'Failed to save progress. Please try again.');
@@ -162,8 +168,9 @@ const RoutineDetail: React.FC<RoutineDetailProps> = ({ route, navigation }) => {
 
   const renderWorkout = (workout: Workout, index: number) => {
     const typeInfo = getWorkoutTypeInfo(workout);
-    const displayWeight = workout.userWeight || workout.weight;
-    const displayTime = workout.userTime || workout.time;
+    const displayWeight = workout.userWeight || workout.suggestedWeight || workout.weight;
+    const displayTime = workout.userTime || workout.suggestedTime || workout.time;
+    const displayReps = workout.suggestedReps || workout.reps;
     const hasBeenUpdated = workout.userWeight || workout.userTime;
 
     const animatedStyle = {
@@ -218,10 +225,10 @@ const RoutineDetail: React.FC<RoutineDetailProps> = ({ route, navigation }) => {
                         <Text style={styles.detailValue}>{workout.sets} sets</Text>
                       </View>
                     )}
-                    {workout.reps && (
+                    {displayReps && (
                       <View style={styles.detailItem}>
                         <Icon name="fitness-outline" size={14} color={colors.text.tertiary} />
-                        <Text style={styles.detailValue}>{workout.reps} reps</Text>
+                        <Text style={styles.detailValue}>{displayReps} reps</Text>
                       </View>
                     )}
                   </View>
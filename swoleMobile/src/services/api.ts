@@ -1,5 +1,5 @@
 import { ENV } from '../config/env';
-import { WeekSchedule, Routine, Workout } from '../types';
+import { WeekSchedule, Routine, Workout, PersonalRecord, SyncMutation, SyncResponse } from '../types';
 import { mockWeekSchedule, mockRoutines } from '../data/mockData';
 
 const API_BASE_URL = ENV.API_BASE_URL;
@@ -41,6 +41,16 @@ async function fetchWithTimeout(
   }
 }
 
+// Writes that failed while offline, replayed in order by syncPendingChanges.
+// Kept in memory, so they survive losing signal but not an app restart.
+let pendingMutations: SyncMutation[] = [];
+let syncToken: string | undefined;
+let syncInFlight: Promise<SyncResponse | null> | null = null;
+
+function generateClientId(): string {
+  return `${Date.now().toString(36)}-${Math.random().toString(36).slice(2, 10)}`;
+}
+
 // Retry wrapper for API calls
 async function withRetry<T>(
   operation: () => Promise<T>, 
@@ -173,7 +183,7 @@ export class ApiService {
     userWeight?: number, 
     userTime?: number,
     userId: string = DEFAULT_USER_ID
-  ): Promise<{ status: string }> {
+  ): Promise<{ status: 'success' | 'queued'; records?: PersonalRecord[] }> {
     if (CONFIG.USE_MOCK_DATA) {
       await new Promise(resolve => setTimeout(resolve, 500));
       return { status: 'success' };
@@ -198,15 +208,70 @@ export class ApiService {
         }
         
         const data = await response.json();
+        if (pendingMutations.length > 0) {
+          // Back online: replay anything queued earlier
+          void ApiService.syncPendingChanges(userId);
+        }
         return data;
       });
     } catch (error) {
-      console.warn('Failed to update workout progress via API, using mock response:', error);
-      
-      // Simulate successful update locally
-      await new Promise(resolve => setTimeout(resolve, 300));
-      return { status: 'success' };
+      console.warn('Failed to update workout progress via API, queueing for sync:', error);
+
+      pendingMutations.push({
+        client_id: generateClientId(),
+        type: 'progress.log',
+        occurred_at: new Date().toISOString(),
+        workout_id: workoutId,
+        userWeight,
+        userTime,
+      });
+      return { status: 'queued' };
+    }
+  }
+
+  // Replays queued writes and fetches server changes since the last sync.
+  // Mutations that failed on the server stay queued; everything else is
+  // done. Returns null when the server can't be reached.
+  static async syncPendingChanges(userId: string = DEFAULT_USER_ID): Promise<SyncResponse | null> {
+    if (CONFIG.USE_MOCK_DATA) {
+      return null;
+    }
+    if (syncInFlight) {
+      return syncInFlight;
     }
+
+    const batch = [...pendingMutations];
+    syncInFlight = (async () => {
+      try {
+        const response = await fetchWithTimeout(`${API_BASE_URL}/sync`, {
+          method: 'POST',
+          body: JSON.stringify({ user_id: userId, sync_token: syncToken, mutations: batch }),
+        });
+
+        if (!response.ok) {
+          throw new Error(`HTTP ${response.status}: ${response.statusText}`);
+        }
+
+        const data: SyncResponse = await response.json();
+        const retry = new Set(
+          data.results.filter(r => r.status === 'failed').map(r => r.client_id)
+        );
+        const sent = new Set(batch.map(m => m.client_id));
+        pendingMutations = pendingMutations.filter(m => !sent.has(m.client_id) || retry.has(m.client_id));
+        syncToken = data.sync_token;
+        return data;
+      } catch (error) {
+        console.warn('Failed to sync queued changes, keeping them for later:', error);
+        return null;
+      } finally {
+        syncInFlight = null;
+      }
+    })();
+    return syncInFlight;
+  }
+
+  static getPendingChangeCount(): number {
+    return pendingMutations.length;
   }
 
   static async getUserProgress(
@@ -235,11 +300,11 @@ export class ApiService {
         const data = await response.json();
         
         // Validate response structure
-        if (!Array.isArray(data)) {
+        if (!data || !Array.isArray(data.progress)) {
           throw new Error('Invalid progress response format');
         }
         
-        return data;
+        return data.progress;
       });
     } catch (error) {
       console.warn('Failed to fetch user progress from API, returning empty array:', error);
@@ -247,6 +312,30 @@ export class ApiService {
     }
   }
 
+  static async getPersonalRecords(userId: string = DEFAULT_USER_ID): Promise<PersonalRecord[]> {
+    if (CONFIG.USE_MOCK_DATA) {
+      return [];
+    }
+
+    try {
+      return await withRetry(async () => {
+        const response = await fetchWithTimeout(
+          `${API_BASE_URL}/users/${encodeURIComponent(userId)}/records?current=true`
+        );
+        
+        if (!response.ok) {
+          throw new Error(`HTTP ${response.status}: ${response.statusText}`);
+        }
+        
+        const data = await response.json();
+        return Array.isArray(data) ? data : [];
+      });
+    } catch (error) {
+      console.warn('Failed to fetch personal records from API, returning empty array:', error);
+      return [];
+    }
+  }
+
   // Health check method
   static async checkApiHealth(): Promise<boolean> {
     try {
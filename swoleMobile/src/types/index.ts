@@ -13,12 +13,16 @@ export interface Workout {
   description?: string;
   userWeight?: number;
   userTime?: number;
+  suggestedWeight?: number;
+  suggestedReps?: number;
+  suggestedTime?: number;
 }
 
 export interface Routine {
   id: string;
   name: string;
   description?: string;
+  version?: number;
   workouts: Workout[];
 }
 
@@ -28,5 +32,58 @@ export interface DaySchedule {
 }
 
 export interface WeekSchedule {
+  id?: string;
+  version?: number;
   schedule: DaySchedule[];
-}
\ No newline at end of file
+}
+
+export type RecordType = 'weight' | 'time' | 'e1rm' | 'rep_max';
+
+export interface PersonalRecord {
+  id: string;
+  exercise_id: string;
+  exercise_name?: string;
+  workout_id: string;
+  type: RecordType;
+  value: number;
+  previous_value?: number;
+  weight?: number;
+  reps?: number;
+  date: string;
+}
+export type SyncMutationType = 'progress.log' | 'progress.delete' | 'session.start' | 'session.finish';
+
+// A write queued while offline, replayed through POST /api/sync
+export interface SyncMutation {
+  client_id: string;
+  type: SyncMutationType;
+  occurred_at: string;
+  workout_id?: string;
+  userWeight?: number;
+  userTime?: number;
+  progress_id?: string;
+  progress_client_id?: string;
+  session_id?: string;
+  session_client_id?: string;
+}
+
+export type SyncStatus = 'applied' | 'duplicate' | 'superseded' | 'rejected' | 'failed';
+
+export interface SyncResult {
+  client_id: string;
+  status: SyncStatus;
+  id?: string;
+  error?: string;
+  records?: PersonalRecord[];
+}
+
+export interface SyncResponse {
+  results: SyncResult[];
+  changes: {
+    progress: Array<{ id: string; workout_id: string; date: string; weight?: number; time?: number }>;
+    deleted_progress: string[];
+    sessions: Array<{ id: string; started_at: string; ended_at?: string }>;
+  };
+  reset: boolean;
+  sync_token: string;
+}